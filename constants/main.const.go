@@ -6,4 +6,54 @@ const (
 	Prod           = "prod"
 	Go             = "go"
 	Dotnet         = "dotnet"
+	Node           = "node"
+	Python         = "python"
+	Rust           = "rust"
+	Java           = "java"
+	Shell          = "shell"
+
+	// Deployment strategies
+	RollingStrategy  = "rolling"
+	RecreateStrategy = "recreate"
+
+	// Ways of making a built image available to the cluster
+	MinikubeDelivery = "minikube"
+	KindDelivery     = "kind"
+	RegistryDelivery = "registry"
+
+	// What configured build paths (BuildOutputDirectory, Docker contexts) are relative to
+	ServiceRelative = "service"
+	RootRelative    = "root"
+
+	// Version bump levels
+	MajorBump      = "major"
+	MinorBump      = "minor"
+	PatchBump      = "patch"
+	PrereleaseBump = "prerelease"
+
+	// Versioning strategies
+	SemVerVersioning              = "semver"
+	GitShaVersioning              = "git-sha"
+	TimestampVersioning           = "timestamp"
+	CalVerVersioning              = "calver"
+	ConventionalCommitsVersioning = "conventional-commits"
+)
+
+// Process exit codes, one per failure class. When several services fail in one run, the
+// exit code of the first failed service (in deploy order) is used.
+const (
+	ExitOK                 = 0  // every selected service succeeded
+	ExitFailure            = 1  // a failure that fits no other class
+	ExitUsage              = 2  // invalid flags, operation, mode or service selection
+	ExitConfig             = 3  // missing, unreadable or invalid k8s-deployer.config.json
+	ExitServiceNotFound    = 4  // a service is not configured in ServicesDirectory.All
+	ExitUnknownServiceType = 5  // no builder is registered for the service type
+	ExitServiceDirectory   = 6  // a configured service directory is missing or not a directory
+	ExitManifest           = 7  // a Kubernetes manifest is missing or can't be parsed or updated
+	ExitVersion            = 8  // the image reference or its version tag is invalid
+	ExitBuild              = 9  // compiling the service or building its Docker image failed
+	ExitDeploy             = 10 // delivering the image or applying a manifest failed
+	ExitRollout            = 11 // the rollout never became healthy (rolled back when possible)
+	ExitCluster            = 12 // the kube context is missing or points at another cluster
+	ExitDrift              = 13 // status found a service off its committed image or could not read it
 )
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
 	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
@@ -17,80 +19,285 @@ func main() {
 		panic(err)
 	}
 
-	cfg, err := utils.ParseConfig(cwd)
-
-	if err != nil {
-		fmt.Println(err.Error())
-
-		os.Exit(1)
-	}
+	os.Exit(runK8sDeployer(cwd))
+}
 
-	runK8sDeployer(cwd, cfg)
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: k8s-deployer [flags] <build|deploy|bnd|rollback|history|status|diff>\n\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), `
+Exit codes:
+  %d  success
+  %d  unclassified failure
+  %d  invalid flags, operation, mode or service selection
+  %d  missing or invalid %s
+  %d  service not found in ServicesDirectory.All
+  %d  unknown service type
+  %d  service directory missing or not a directory
+  %d  Kubernetes manifest missing or invalid
+  %d  invalid image reference or version
+  %d  build failed
+  %d  image delivery or kubectl apply failed
+  %d  rollout unhealthy
+  %d  kube context missing or pointing at an unexpected cluster
+  %d  status found drift, or could not read the status of a service
+`,
+		constants.ExitOK,
+		constants.ExitFailure,
+		constants.ExitUsage,
+		constants.ExitConfig,
+		constants.ConfigFileName,
+		constants.ExitServiceNotFound,
+		constants.ExitUnknownServiceType,
+		constants.ExitServiceDirectory,
+		constants.ExitManifest,
+		constants.ExitVersion,
+		constants.ExitBuild,
+		constants.ExitDeploy,
+		constants.ExitRollout,
+		constants.ExitCluster,
+		constants.ExitDrift,
+	)
 }
 
-func runK8sDeployer(cwd string, cfg *types.K8sDeployerConfig) {
+func runK8sDeployer(cwd string) int {
 	// Define command line flags
-	var mode, serviceType, serviceName string
+	var mode, serviceType, serviceNames string
+	var bump, version, changedSince, output, rollbackTo string
+	var all, dryRun, force, writeLog, diff, confirm bool
+	var jobs int
 	var operation string
 
-	flag.StringVar(&mode, "mode", "dev", "Set the mode (dev/prod)")
-	flag.StringVar(&serviceType, "type", constants.Go, "Set the service type (go/dotnet)")
-	flag.StringVar(&serviceName, "svc", "", "Set the service name from the list you've configured in the `"+constants.ConfigFileName+"` file")
+	flag.StringVar(&mode, "mode", "dev", "Set the mode, one of the environments configured in the `"+constants.ConfigFileName+"` file (dev/prod by default)")
+	flag.StringVar(&serviceType, "type", "", "Set the service type ("+strings.Join(utils.RegisteredServiceTypes(), "/")+"), looked up in the `"+constants.ConfigFileName+"` file when empty")
+	flag.StringVar(&serviceNames, "svc", "", "Set the comma separated service names from the list you've configured in the `"+constants.ConfigFileName+"` file")
+	flag.BoolVar(&all, "all", false, "Select every configured service, or every service of --type")
+	flag.IntVar(&jobs, "jobs", 4, "Number of services built in parallel")
+	flag.StringVar(&bump, "bump", "", "Bump the version by major/minor/patch/prerelease instead of the service's versioning strategy")
+	flag.StringVar(&version, "version", "", "Use this exact version for the new image")
+	flag.StringVar(&rollbackTo, "to", "", "Version or image `rollback` returns to, the previous successful deploy when empty")
+	flag.StringVar(&changedSince, "changed-since", "", "Only run the selected services (every service without --svc) affected by the changes since this git ref")
+	flag.BoolVar(&force, "force", false, "Rebuild services even when their sources haven't changed since the last build")
+	flag.BoolVar(&diff, "diff", false, "Show how the manifests differ from the live objects before applying them")
+	flag.BoolVar(&confirm, "confirm", false, "Like --diff, and ask for approval when more than the container images change")
+	flag.BoolVar(&writeLog, "log", true, "Write a transcript of the run to .k8s-deployer/logs")
+	flag.StringVar(&output, "output", "text", "Output format: text, or json for newline-delimited events on stdout (the log then goes to stderr)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the plan, the YAML changes and every command that would change something, without running those or writing any file")
 
 	// Parse command line flags
+	flag.Usage = usage
 	flag.Parse()
 
-	if len(flag.Args()) < 1 {
-		fmt.Println("[!] Not enough arguments provided. Usage: k8s-deployer <operation>")
-		os.Exit(1)
+	if flag.NArg() > 0 {
+		// operation: build | deploy | bnd (build-and-deploy) | rollback | history | status | diff
+		operation = flag.Arg(0)
 	}
 
-	// operation: build | deploy | bnd (build-and-deploy)
-	operation = flag.Args()[0]
+	// fail reports an error that ends the run before any service ran
+	fail := func(err error) int {
+		fmt.Println(err.Error())
+		utils.EmitResult(operation, utils.ExitCode(err), nil, err)
+
+		return utils.ExitCode(err)
+	}
 
-	if operation != "build" && operation != "deploy" && operation != "bnd" {
-		fmt.Println("[!] Unknown operation: " + operation)
+	switch output {
+	case "text":
+	case "json":
+		// Events own stdout, everything meant for humans goes to stderr
+		utils.SetEventEmitter(utils.NewEventEmitter(os.Stdout))
+		os.Stdout = os.Stderr
+	default:
+		// Without a known format there is no event stream, the error goes to stderr so it
+		// never mixes with what a caller parses from stdout
+		os.Stdout = os.Stderr
 
-		os.Exit(1)
+		return fail(&utils.UsageError{Reason: "Unknown output format: " + output + " (expected text or json)"})
 	}
 
-	// Check if service name is provided and exists
-	if serviceName == "" {
-		fmt.Println("[!] No service name provided.")
-		os.Exit(1)
+	if operation == "" {
+		return fail(&utils.UsageError{Reason: "Not enough arguments provided. Usage: k8s-deployer <operation>"})
 	}
 
 	switch operation {
-	case "build":
-		_, err := utils.Build(cfg, cwd, mode, serviceType, serviceName)
+	case "build", "deploy", "bnd", "rollback", "history", "status", "diff":
+	default:
+		return fail(&utils.UsageError{Reason: "Unknown operation: " + operation})
+	}
+
+	if rollbackTo != "" && operation != "rollback" {
+		return fail(&utils.UsageError{Reason: "--to only applies to rollback"})
+	}
+
+	cfg, err := utils.ParseConfig(cwd)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	// history, status and diff only read, there is nothing worth a transcript, and a dry
+	// run writes no file
+	if writeLog && !dryRun && operation != "history" && operation != "status" && operation != "diff" {
+		runLog, err := utils.StartRunLog(cwd)
 
 		if err != nil {
 			fmt.Println(err.Error())
+		} else {
+			defer func() {
+				runLog.Close()
+				fmt.Printf("[+] The transcript of this run is in %s\n", runLog.Path)
+			}()
+		}
+	}
+
+	if _, err := utils.GetEnvironment(cfg, mode); err != nil {
+		return fail(err)
+	}
+
+	if (changedSince != "" || operation == "status") && strings.TrimSpace(serviceNames) == "" {
+		all = true
+	}
+
+	targets, err := utils.ResolveServiceTargets(cfg, strings.Split(serviceNames, ","), serviceType, all)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if changedSince != "" {
+		changedFiles, err := utils.ChangedFiles(cwd, changedSince)
 
-			os.Exit(1)
+		if err != nil {
+			return fail(err)
 		}
-	case "deploy":
-		if err := utils.DeployAlone(cfg, cwd, mode, serviceType, serviceName); err != nil {
-			os.Exit(1)
+
+		affected := utils.AffectedServices(cfg, targets, changedFiles)
+		utils.PrintAffectedServices(os.Stdout, changedSince, affected)
+
+		if len(affected) == 0 {
+			utils.EmitResult(operation, constants.ExitOK, nil, nil)
+
+			return constants.ExitOK
 		}
-	case "bnd":
-		buildInfo, err := utils.Build(cfg, cwd, mode, serviceType, serviceName)
 
-		if err != nil {
-			os.Exit(1)
+		targets = utils.AffectedTargets(affected)
+	}
+
+	args := &types.Args{
+		DeployTo:         mode,
+		MicroserviceType: serviceType,
+		Bump:             bump,
+		Version:          version,
+		DryRun:           dryRun,
+		Force:            force,
+		RollbackTo:       rollbackTo,
+		Diff:             diff,
+		Confirm:          confirm,
+	}
+
+	if rollbackTo != "" && len(targets) > 1 {
+		return fail(&utils.UsageError{Reason: "--to needs a single service, got " + strconv.Itoa(len(targets))})
+	}
+
+	if operation == "history" {
+		for _, target := range targets {
+			if err := utils.PrintHistory(os.Stdout, cfg, args, cwd, target.Name); err != nil {
+				return fail(err)
+			}
 		}
 
-		if err := utils.DeployAfterBuild(cfg, buildInfo, mode, serviceName); err != nil {
-			os.Exit(1)
+		utils.EmitResult(operation, constants.ExitOK, nil, nil)
+
+		return constants.ExitOK
+	}
+
+	if operation == "diff" {
+		for _, target := range targets {
+			serviceArgs := *args
+			serviceArgs.MicroserviceType = target.Type
+
+			diffs, err := utils.DiffManifests(cfg, &serviceArgs, cwd, target.Name)
+			if err != nil {
+				return fail(err)
+			}
+
+			utils.PrintObjectDiffs(os.Stdout, diffs)
 		}
-	default:
-		fmt.Printf("[!] Unknown operation: %s\n", operation)
-		os.Exit(1)
+
+		utils.EmitResult(operation, constants.ExitOK, nil, nil)
+
+		return constants.ExitOK
 	}
 
-	fmt.Printf(
-		"[+] '%s' operation completed successfully for %s.\n",
-		operation,
-		utils.ParseServiceName(cfg.DockerImagePrefix, serviceName),
-	)
+	if operation == "status" {
+		statuses := make([]*utils.ServiceStatus, len(targets))
+
+		for i, target := range targets {
+			statuses[i] = utils.GetServiceStatus(cfg, args, cwd, target)
+		}
+
+		utils.PrintStatus(os.Stdout, mode, statuses)
+		utils.EmitStatus(statuses)
+
+		code := constants.ExitOK
+
+		if utils.StatusDrifted(statuses) {
+			code = constants.ExitDrift
+		}
+
+		utils.EmitResult(operation, code, nil, nil)
+
+		return code
+	}
+
+	if dryRun {
+		utils.SetCommandRunner(&utils.DryRunRunner{Out: os.Stdout})
+
+		// One service at a time keeps the printed plan readable
+		jobs = 1
+	}
+
+	results, err := utils.RunPipeline(cfg, args, cwd, operation, targets, jobs)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("\n[!] %s failed:\n%s\n", result.Target.Name, result.Err.Error())
+		}
+	}
+
+	fmt.Println()
+	utils.PrintSummary(os.Stdout, results)
+
+	code := constants.ExitOK
+
+	if utils.PipelineFailed(results) {
+		code = constants.ExitFailure
+
+		for _, result := range results {
+			if result.Err != nil {
+				code = utils.ExitCode(result.Err)
+				break
+			}
+		}
+	}
+
+	utils.EmitResult(operation, code, results, nil)
+
+	if code != constants.ExitOK {
+		return code
+	}
+
+	if dryRun {
+		fmt.Printf("[dry-run] '%s' plan printed for %d service(s); no command was run and no file was written.\n", operation, len(results))
+
+		return constants.ExitOK
+	}
+
+	fmt.Printf("[+] '%s' operation completed successfully for %d service(s).\n", operation, len(results))
+
+	return constants.ExitOK
 }
@@ -2,6 +2,13 @@ package types
 
 // Command line arguments
 type Args struct {
-	DeployTo         string // "dev" or "prod"
-	MicroserviceType string // "go" or "dotnet"
+	DeployTo         string // environment name, an Environments key or a name matching one of its glob patterns
+	MicroserviceType string // any service type with a registered builder, e.g. "go" or "dotnet"
+	Bump             string // "major", "minor", "patch" or "prerelease", empty to use the service's versioning strategy
+	Version          string // explicit version, overrides Bump and the versioning strategy
+	DryRun           bool   // print the plan without running any command that changes something or writing any file
+	Force            bool   // rebuild even when the service's sources are unchanged
+	RollbackTo       string // version or image a rollback returns to, the previous successful deploy when empty
+	Diff             bool   // show how the manifests differ from the cluster before applying them
+	Confirm          bool   // like Diff, and ask for approval when more than the images change
 }
@@ -1,44 +0,0 @@
-package types
-
-// Define structs based on the YAML structure
-type Deployment struct {
-	APIVersion string `yaml:"apiVersion"`
-	Kind       string `yaml:"kind"`
-	Metadata   struct {
-		Name string `yaml:"name"`
-	} `yaml:"metadata"`
-	Spec struct {
-		Replicas int `yaml:"replicas"`
-		Selector struct {
-			MatchLabels map[string]string `yaml:"matchLabels"`
-		} `yaml:"selector"`
-		Template struct {
-			Metadata struct {
-				Labels      map[string]string `yaml:"labels"`
-				Annotations map[string]string `yaml:"annotations"`
-			} `yaml:"metadata"`
-			Spec struct {
-				Containers []struct {
-					Name            string `yaml:"name"`
-					Image           string `yaml:"image"`
-					ImagePullPolicy string `yaml:"imagePullPolicy"`
-					Ports           []struct {
-						ContainerPort int `yaml:"containerPort"`
-					} `yaml:"ports"`
-					Resources struct {
-						Requests map[string]string `yaml:"requests"`
-						Limits   map[string]string `yaml:"limits"`
-					} `yaml:"resources"`
-					EnvFrom []struct {
-						ConfigMapRef struct {
-							Name string `yaml:"name"`
-						} `yaml:"configMapRef"`
-					} `yaml:"envFrom"`
-				} `yaml:"containers"`
-				ImagePullSecrets []struct {
-					Name string `yaml:"name"`
-				} `yaml:"imagePullSecrets"`
-			} `yaml:"spec"`
-		} `yaml:"template"`
-	} `yaml:"spec"`
-}
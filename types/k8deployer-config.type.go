@@ -2,11 +2,16 @@ package types
 
 // Base type for the Kubernetes Deployer config json
 type K8sDeployerConfig struct {
-	DockerImagePrefix       string            `json:"DockerImagePrefix"`
-	DockerContainerRegistry DockerRegistry    `json:"DockerContainerRegistry"`
-	BuildOutputDirectory    string            `json:"BuildOutputDirectory"`
-	KubernetesConfig        KubernetesConfig  `json:"KbernetesConfig"`
-	ServicesDirectory       ServicesDirectory `json:"ServicesDirectory"`
+	DockerImagePrefix       string                       `json:"DockerImagePrefix"`
+	DockerContainerRegistry DockerRegistry               `json:"DockerContainerRegistry"`
+	BuildOutputDirectory    string                       `json:"BuildOutputDirectory"`  // default "build"
+	BuildOutputRelativeTo   string                       `json:"BuildOutputRelativeTo"` // "service" (default) or "root", inside the Docker context either way
+	IsolatedBuildOutput     bool                         `json:"IsolatedBuildOutput"`   // build every run into its own temporary directory
+	KubernetesConfig        KubernetesConfig             `json:"KbernetesConfig"`
+	ServicesDirectory       ServicesDirectory            `json:"ServicesDirectory"`
+	Services                map[string]ServiceConfig     `json:"Services"`
+	Environments            map[string]EnvironmentConfig `json:"Environments"`
+	SharedPaths             []string                     `json:"SharedPaths"` // paths or globs whose changes affect every service, e.g. ["go.mod"]
 }
 
 // Struct for Docker container registry settings
@@ -15,18 +20,47 @@ type DockerRegistry struct {
 	Prod string `json:"Prod"`
 }
 
+// Struct for a named environment selected with --mode. Keys may be glob patterns such as
+// "preview-*". When no environments are configured, "dev" and "prod" are derived from
+// DockerContainerRegistry and KubernetesConfig.Files.
+type EnvironmentConfig struct {
+	Name             string           `json:"-"`                // the --mode value this environment was resolved for
+	Registry         string           `json:"Registry"`         // container registry the images are tagged for
+	Files            EnvironmentFiles `json:"Files"`            // manifest file names inside the service's Kubernetes directory
+	Kubeconfig       string           `json:"Kubeconfig"`       // kubeconfig file ("~/" and relative paths allowed), empty for kubectl's default
+	KubeContext      string           `json:"KubeContext"`      // kubectl context, empty for the current one
+	Namespace        string           `json:"Namespace"`        // kubectl namespace, empty for the context's default
+	ClusterServer    string           `json:"ClusterServer"`    // API server URL the context has to point at, e.g. "https://10.0.0.1:6443"
+	ImageDelivery    string           `json:"ImageDelivery"`    // "minikube", "kind" or "registry"
+	KindCluster      string           `json:"KindCluster"`      // cluster name for "kind" delivery, default "kind"
+	HistoryConfigMap string           `json:"HistoryConfigMap"` // ConfigMap in Namespace sharing the deploy history with everyone deploying, empty to keep it local only
+}
+
 // Struct for Kubernetes configuration
 type KubernetesConfig struct {
 	Directory DirectoryConfig `json:"Directory"`
 	Files     FileConfig      `json:"Files"`
+	Clusters  ClustersConfig  `json:"Clusters"`
 }
 
-// Struct for directory configuration
-type DirectoryConfig struct {
-	Go     string `json:"Go"`
-	Dotnet string `json:"Dotnet"`
+// Cluster the legacy dev/prod environments deploy to
+type ClustersConfig struct {
+	Dev  ClusterConfig `json:"Dev"`
+	Prod ClusterConfig `json:"Prod"`
 }
 
+// Struct for the kubectl target of an environment, see EnvironmentConfig
+type ClusterConfig struct {
+	Kubeconfig       string `json:"Kubeconfig"`
+	KubeContext      string `json:"KubeContext"`
+	Namespace        string `json:"Namespace"`
+	ClusterServer    string `json:"ClusterServer"`
+	HistoryConfigMap string `json:"HistoryConfigMap"`
+}
+
+// Kubernetes manifest directory inside a service, keyed by service type ("Go", "Dotnet", "Node", ...)
+type DirectoryConfig map[string]string
+
 // Struct for file configuration
 type FileConfig struct {
 	Dev  EnvironmentFiles `json:"Dev"`
@@ -45,14 +79,87 @@ type ServicesDirectory struct {
 	All  AllServices           `json:"All"`
 }
 
-// Struct for ServicesDirectory.Root
-type ServicesDirectoryRoot struct {
-	Go     string `json:"Go"`
-	Dotnet string `json:"Dotnet"`
+// Root directory of the services, keyed by service type ("Go", "Dotnet", "Node", ...)
+type ServicesDirectoryRoot map[string]string
+
+// Service name to directory maps, keyed by service type ("Go", "Dotnet", "Node", ...)
+type AllServices map[string]map[string]string
+
+// Struct for per-service settings, keyed by the service name used in ServicesDirectory.All
+type ServiceConfig struct {
+	Strategy       string           `json:"Strategy"`       // "rolling" (default) or "recreate"
+	RolloutTimeout string           `json:"RolloutTimeout"` // e.g. "5m" (default), how long to wait for healthy pods
+	Versioning     VersioningConfig `json:"Versioning"`
+	Build          BuildConfig      `json:"Build"`
+	DependsOn      []string         `json:"DependsOn"` // services that have to be deployed before this one
+	Platforms      []string         `json:"Platforms"` // e.g. ["linux/amd64", "linux/arm64"], built with docker buildx
+	Docker         DockerConfig     `json:"Docker"`
+	Cache          CacheConfig      `json:"Cache"`
+	SharedPaths    []string         `json:"SharedPaths"` // paths or globs outside the service directory it is built from, e.g. ["pkg", "libs/auth"]
+	Manifests      ManifestConfig   `json:"Manifests"`
+}
+
+// Struct for the manifests a service applies besides its deployment and service YAML, such
+// as ConfigMaps, HPAs or Ingresses. Paths are files, directories (their *.yaml, *.yml and
+// *.json files) or globs, relative to the service's Kubernetes directory.
+type ManifestConfig struct {
+	Paths        []string            `json:"Paths"`        // applied in every environment
+	Environments map[string][]string `json:"Environments"` // applied too, keyed by environment name or glob pattern
+}
+
+// Struct for the source files that decide whether a service has to be rebuilt. Globs are
+// relative to the service directory and `**` matches any number of directories; the
+// .dockerignore of the Docker context is always respected.
+type CacheConfig struct {
+	Disabled bool     `json:"Disabled"` // rebuild on every run
+	Include  []string `json:"Include"`  // only hash files matching these, e.g. ["**/*.go", "go.*"]
+	Exclude  []string `json:"Exclude"`  // never hash files matching these, e.g. ["docs/**", "**/*_test.go"]
+}
+
+// Struct for the `docker build` settings of a service
+type DockerConfig struct {
+	Context           string            `json:"Context"`           // build context, default the service directory
+	ContextRelativeTo string            `json:"ContextRelativeTo"` // "service" (default) or "root"
+	Dockerfile        string            `json:"Dockerfile"`        // relative to the service directory, default "Dockerfile" in the context
+	BuildArgs         map[string]string `json:"BuildArgs"`         // --build-arg values, templated with {{.Version}}, {{.GitSha}}, ...
+	Target            string            `json:"Target"`            // --target stage of a multi-stage Dockerfile
+	Secrets           []string          `json:"Secrets"`           // --secret values, e.g. "id=npmrc,src=/home/me/.npmrc"
+	Tags              []string          `json:"Tags"`              // extra tags or full image references, templated like BuildArgs
+
+	// Overrides per environment, keyed by name or glob pattern like Environments. Set values
+	// replace the service's, BuildArgs are merged and Secrets and Tags are added.
+	Environments map[string]DockerConfig `json:"Environments"`
+}
+
+// Struct for the toolchain settings handed to a service type's builder
+type BuildConfig struct {
+	Tool    string            `json:"Tool"`    // toolchain variant, e.g. npm/pnpm/yarn, pip/poetry, maven/gradle (detected when empty)
+	Command string            `json:"Command"` // command run by the "shell" builder through `sh -c`
+	Args    []string          `json:"Args"`    // extra arguments appended to the build command
+	Env     map[string]string `json:"Env"`     // extra environment variables for the build command
+	Go      GoBuildConfig     `json:"Go"`
+}
+
+// Struct for the `go build` settings of a Go service
+type GoBuildConfig struct {
+	GOOS       string   `json:"GOOS"`       // default "linux"
+	GOARCH     string   `json:"GOARCH"`     // default "amd64", e.g. "arm64"
+	CGOEnabled bool     `json:"CGOEnabled"` // CGO_ENABLED=1 instead of a static binary
+	Tags       []string `json:"Tags"`       // build tags passed with -tags
+	TrimPath   bool     `json:"TrimPath"`   // pass -trimpath
+	LDFlags    string   `json:"LDFlags"`    // extra -ldflags, e.g. "-s -w"
+	Package    string   `json:"Package"`    // package to build, e.g. "./cmd/server", default the service root
+
+	// Fully qualified string variables set with -X, e.g. "main.version"
+	VersionVariable   string `json:"VersionVariable"`   // the computed image version
+	CommitVariable    string `json:"CommitVariable"`    // the short git commit of the service directory
+	BuildTimeVariable string `json:"BuildTimeVariable"` // the build time in RFC 3339, UTC
 }
 
-// Struct for all services
-type AllServices struct {
-	Go     map[string]string `json:"Go"`
-	Dotnet map[string]string `json:"Dotnet"`
+// Struct for the way a service's next image version is computed
+type VersioningConfig struct {
+	Strategy             string `json:"Strategy"`             // "semver" (default), "git-sha", "timestamp", "calver" or "conventional-commits"
+	PrereleaseIdentifier string `json:"PrereleaseIdentifier"` // e.g. "rc" (default) for 1.2.4-rc.0
+	TimestampFormat      string `json:"TimestampFormat"`      // Go time layout for "timestamp", default "20060102150405"
+	TagPattern           string `json:"TagPattern"`           // git tag glob marking the last release for "conventional-commits", default "*"
 }
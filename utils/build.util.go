@@ -1,170 +1,361 @@
 package utils
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
 	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
 )
 
+const defaultBuildOutputDirectory = "build"
+
 type BuildInfo struct {
-	ServiceDirectoryRoot string
-	DeploymentYamlPath   string
-	ServiceYamlPath      string
-	NewDockerImagePath   string
-	NextVersion          string
+	ProjectRoot           string // directory of the config file the build ran from
+	ServiceDirectoryRoot  string
+	DeploymentYamlPath    string
+	ServiceYamlPath       string
+	NewDockerImagePath    string
+	NextVersion           string
+	ExtraDockerImagePaths []string // the new image under its extra tags
+	Cached                bool     // the sources or the version were unchanged and the previous image is reused
+
+	// Image the deployment YAML referenced before the build, restored if the rollout fails
+	PreviousDockerImagePath string
 }
 
 func Build(
 	cfg *types.K8sDeployerConfig,
-	cwd, mode, serviceType, serviceName string,
+	args *types.Args,
+	cwd, serviceName string,
 ) (*BuildInfo, error) {
-	fmt.Println("[+] Build process started...")
+	serviceType := args.MicroserviceType
+	label := serviceLabel(serviceType, serviceName)
+
+	fmt.Printf("[+] %s: Build process started...\n", label)
 
-	serviceDirectoryRoot := GetServiceDirectoryRoot(cfg, cwd, serviceType, serviceName)
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return nil, err
+	}
 
-	output, err := buildMicroserviceBinary(cfg, serviceDirectoryRoot, serviceType, serviceName)
+	serviceConfig, err := GetServiceConfig(cfg, serviceName)
+	if err != nil {
+		return nil, err
+	}
 
+	serviceDirectoryRoot, err := GetServiceDirectoryRoot(cfg, cwd, serviceType, serviceName)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("[+] Build process completed...")
-	fmt.Println(output)
+	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, env, serviceDirectoryRoot, serviceType, serviceName)
 
-	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, serviceDirectoryRoot, mode, serviceType, serviceName)
+	fmt.Printf("[+] %s: Parsing deployment YAML file: %s\n", label, deploymentYamlPath)
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, serviceName)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("[+] Parsing deployment YAML file: %s\n", deploymentYamlPath)
+	dockerImagePath := workload.Image
+	previousDockerImagePath := dockerImagePath
 
-	deployment, err := ParseYaml(deploymentYamlPath)
+	fmt.Printf("[+] %s: Extracting current version of the Docker image and generating the next verison...\n", label)
+	currentVersion, err := ParseVersion(dockerImagePath)
 
 	if err != nil {
 		return nil, err
-	} else if deployment == nil {
-		return nil, fmt.Errorf("[!] Failed to parse deployment YAML file")
 	}
 
-	if len(deployment.Spec.Template.Spec.Containers) == 0 {
-		return nil, fmt.Errorf("[!] Failed to find container in deployment YAML file")
+	hash, cached, err := lookupCachedBuild(cfg, env, serviceConfig, args, cwd, serviceDirectoryRoot, filepath.Dir(deploymentYamlPath), serviceName, dockerImagePath)
+	if err != nil {
+		return nil, err
 	}
 
-	dockerImagePath := deployment.Spec.Template.Spec.Containers[0].Image
+	if cached != nil {
+		fmt.Printf("[+] %s: Sources are unchanged since %s was built, reusing it (--force rebuilds)\n", label, cached.Image)
+
+		emitResolvedEvent(env, "build", serviceType, serviceName, cached.Image, previousDockerImagePath, currentVersion, cached.Version, deploymentYamlPath, serviceYamlPath)
 
-	fmt.Println("[+] Extracting current version of the Docker image and generating the next verison...")
-	_, nextVersion := ParseVersion(dockerImagePath)
+		return &BuildInfo{
+			ProjectRoot:          cwd,
+			ServiceDirectoryRoot: serviceDirectoryRoot,
+			DeploymentYamlPath:   deploymentYamlPath,
+			ServiceYamlPath:      serviceYamlPath,
+			NewDockerImagePath:   cached.Image,
+			NextVersion:          cached.Version,
+			Cached:               true,
 
-	if mode != "deploy" {
-		fmt.Printf("[+] Next version: %s\n", nextVersion)
+			PreviousDockerImagePath: previousDockerImagePath,
+		}, nil
+	}
+
+	nextVersion, err := NextVersion(serviceConfig.Versioning, args, serviceDirectoryRoot, currentVersion)
+
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("[+] Building docker image...")
+	fmt.Printf("[+] %s: Next version: %s\n", label, nextVersion)
 
-	dockerImagePath = ParseDockerImagePath(cfg, mode, serviceName, nextVersion)
-	output, err = buildDockerImage(serviceDirectoryRoot, dockerImagePath)
+	dockerImagePath = ParseDockerImagePath(cfg, env, serviceName, nextVersion)
 
+	// Rebuilding under the released tag would push different contents as the same image,
+	// and the unchanged pod template would roll none of it out
+	if dockerImagePath == previousDockerImagePath {
+		fmt.Printf("[+] %s: Keeps version %s, reusing %s instead of rebuilding it\n", label, nextVersion, dockerImagePath)
+
+		emitResolvedEvent(env, "build", serviceType, serviceName, dockerImagePath, previousDockerImagePath, currentVersion, nextVersion, deploymentYamlPath, serviceYamlPath)
+
+		return &BuildInfo{
+			ProjectRoot:          cwd,
+			ServiceDirectoryRoot: serviceDirectoryRoot,
+			DeploymentYamlPath:   deploymentYamlPath,
+			ServiceYamlPath:      serviceYamlPath,
+			NewDockerImagePath:   dockerImagePath,
+			NextVersion:          nextVersion,
+			Cached:               true,
+
+			PreviousDockerImagePath: previousDockerImagePath,
+		}, nil
+	}
+
+	emitResolvedEvent(env, "build", serviceType, serviceName, dockerImagePath, previousDockerImagePath, currentVersion, nextVersion, deploymentYamlPath, serviceYamlPath)
+
+	if args.DryRun {
+		printBuildPlan(env, serviceDirectoryRoot, deploymentYamlPath, serviceYamlPath, currentVersion, nextVersion, dockerImagePath)
+	}
+
+	outputDirectory, err := prepareBuildOutputDirectory(cfg, cwd, serviceDirectoryRoot, serviceName, args.DryRun)
 	if err != nil {
-		fmt.Println(output)
+		return nil, err
+	}
 
+	if cfg.IsolatedBuildOutput && !args.DryRun {
+		defer os.RemoveAll(outputDirectory)
+	}
+
+	platforms, err := selectPlatforms(env, serviceDirectoryRoot, serviceConfig)
+	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println("[+] Building Docker image completed...")
-	fmt.Println(output)
+	dockerBuild, err := newDockerBuild(cfg, env, serviceConfig, cwd, serviceDirectoryRoot, outputDirectory, serviceName, nextVersion, dockerImagePath, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerBuild.Label = label
 
-	fmt.Printf("[+] Updating deployment YAML file: %s\n", deploymentYamlPath)
+	if _, err := buildMicroserviceBinary(cfg, serviceConfig, serviceDirectoryRoot, outputDirectory, serviceType, serviceName, nextVersion, platforms, args.DryRun); err != nil {
+		return nil, &BuildError{ServiceName: serviceName, Err: err}
+	}
 
-	deployment.Spec.Template.Spec.Containers[0].Image = dockerImagePath
+	fmt.Printf("[+] %s: Build process completed...\n", label)
 
-	if err := UpdateYaml(deploymentYamlPath, deployment); err != nil {
-		return nil, err
+	fmt.Printf("[+] %s: Building docker image...\n", label)
+
+	if _, err := buildDockerImage(dockerBuild); err != nil {
+		return nil, &BuildError{ServiceName: serviceName, Err: err}
+	}
+
+	fmt.Printf("[+] %s: Building Docker image completed...\n", label)
+
+	if args.DryRun {
+		diff, err := PreviewYamlUpdate(deploymentYamlPath, dockerImagePath)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Printf("[dry-run] Would update deployment YAML file: %s\n%s", deploymentYamlPath, diff)
+	} else {
+		fmt.Printf("[+] %s: Updating deployment YAML file: %s\n", label, deploymentYamlPath)
+
+		if err := UpdateYaml(deploymentYamlPath, dockerImagePath); err != nil {
+			return nil, err
+		}
+
+		if hash != "" {
+			err := recordBuild(cwd, env.Name, serviceName, ServiceBuildState{
+				SourceHash: hash,
+				Image:      dockerImagePath,
+				Version:    nextVersion,
+				BuiltAt:    buildClock().UTC(),
+			})
+
+			if err != nil {
+				fmt.Printf("[!] Failed to record the build, the next run will rebuild '%s': %v\n", serviceName, err)
+			}
+		}
 	}
 
 	return &BuildInfo{
-		ServiceDirectoryRoot: serviceDirectoryRoot,
-		DeploymentYamlPath:   deploymentYamlPath,
-		ServiceYamlPath:      serviceYamlPath,
-		NewDockerImagePath:   dockerImagePath,
-		NextVersion:          nextVersion,
+		ProjectRoot:           cwd,
+		ServiceDirectoryRoot:  serviceDirectoryRoot,
+		DeploymentYamlPath:    deploymentYamlPath,
+		ServiceYamlPath:       serviceYamlPath,
+		NewDockerImagePath:    dockerImagePath,
+		NextVersion:           nextVersion,
+		ExtraDockerImagePaths: dockerBuild.ExtraImages,
+
+		PreviousDockerImagePath: previousDockerImagePath,
 	}, nil
 }
 
 func buildMicroserviceBinary(
 	cfg *types.K8sDeployerConfig,
-	cwd, serviceType, serviceName string,
+	serviceConfig types.ServiceConfig,
+	cwd, outputDirectory, serviceType, serviceName, version string,
+	platforms []Platform,
+	dryRun bool,
 ) (string, error) {
-	fullServiceName := ParseServiceName(cfg.DockerImagePrefix, serviceName)
-
-	if serviceType == constants.Go {
+	builder, err := GetBuilder(serviceType)
+	if err != nil {
+		return "", err
+	}
 
-		fmt.Printf("[+] Building Go binary for the %s...\n", fullServiceName)
+	if platformBuilder, ok := builder.(PlatformBuilder); ok && platformBuilder.BuildsPerPlatform() && len(platforms) > 0 {
+		var outputs []string
 
-		buildFileName := fmt.Sprintf("%s_%s", cfg.DockerImagePrefix, serviceName)
+		for _, platform := range platforms {
+			output, err := buildMicroserviceBinaryFor(cfg, builder, serviceConfig, cwd, path.Join(outputDirectory, platform.DirectoryName()), serviceType, serviceName, version, &platform, dryRun)
+			outputs = append(outputs, output)
 
-		if cfg.DockerImagePrefix == "" {
-			buildFileName = serviceName
+			if err != nil {
+				return strings.Join(outputs, "\n"), err
+			}
 		}
 
-		buildOutputPath := path.Join(cwd, fmt.Sprintf("/build/%s", buildFileName))
+		return strings.Join(outputs, "\n"), nil
+	}
 
-		os.Remove(buildOutputPath)
+	return buildMicroserviceBinaryFor(cfg, builder, serviceConfig, cwd, outputDirectory, serviceType, serviceName, version, nil, dryRun)
+}
 
-		cmd := exec.Command("go", "build", "-o", buildOutputPath)
+func buildMicroserviceBinaryFor(
+	cfg *types.K8sDeployerConfig,
+	builder Builder,
+	serviceConfig types.ServiceConfig,
+	cwd, outputDirectory, serviceType, serviceName, version string,
+	platform *Platform,
+	dryRun bool,
+) (string, error) {
+	artifactName := fmt.Sprintf("%s_%s", cfg.DockerImagePrefix, serviceName)
 
-		cmd.Dir = cwd
-		cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=amd64", "CGO_ENABLED=0")
+	if cfg.DockerImagePrefix == "" {
+		artifactName = serviceName
+	}
 
-		var output, errOutput bytes.Buffer
-		cmd.Stdout = &output
-		cmd.Stderr = &errOutput
+	return builder.Build(&BuildContext{
+		Cfg:                  cfg,
+		ServiceConfig:        serviceConfig,
+		ServiceName:          serviceName,
+		ServiceType:          serviceType,
+		FullServiceName:      ParseServiceName(cfg.DockerImagePrefix, serviceName),
+		ServiceDirectoryRoot: cwd,
+		OutputDirectory:      outputDirectory,
+		ArtifactName:         artifactName,
+		Version:              version,
+		DryRun:               dryRun,
+		Platform:             platform,
+	})
+}
 
-		if err := cmd.Run(); err != nil {
-			return errOutput.String(), fmt.Errorf("[!] Failed to build service")
-		}
+func printBuildPlan(
+	env *types.EnvironmentConfig,
+	serviceDirectoryRoot, deploymentYamlPath, serviceYamlPath, currentVersion, nextVersion, dockerImagePath string,
+) {
+	fmt.Println("[dry-run] Build plan:")
+	fmt.Printf("[dry-run]     environment:       %s\n", env.Name)
+	fmt.Printf("[dry-run]     service directory: %s\n", serviceDirectoryRoot)
+	fmt.Printf("[dry-run]     deployment YAML:   %s\n", deploymentYamlPath)
+	fmt.Printf("[dry-run]     service YAML:      %s\n", serviceYamlPath)
+	fmt.Printf("[dry-run]     version:           %s -> %s\n", currentVersion, nextVersion)
+	fmt.Printf("[dry-run]     image:             %s\n", dockerImagePath)
+}
 
-		return output.String(), nil
+// buildOutputDirectory resolves BuildOutputDirectory for a service. Relative to the
+// service it is used as is; relative to the root every service gets its own subdirectory.
+func buildOutputDirectory(cfg *types.K8sDeployerConfig, cwd, serviceDirectoryRoot, serviceName string) (string, error) {
+	outputDirectory := cfg.BuildOutputDirectory
 
-	} else if serviceType == constants.Dotnet {
+	if outputDirectory == "" {
+		outputDirectory = defaultBuildOutputDirectory
+	}
 
-		fmt.Printf("[+] Building .NET binary for the '%s'...\n", fullServiceName)
+	if filepath.IsAbs(outputDirectory) {
+		return "", &ConfigError{Reason: fmt.Sprintf("BuildOutputDirectory '%s' has to be a relative path", outputDirectory)}
+	}
 
-		buildOutputPath := path.Join(cwd, "/build")
+	var base string
+
+	switch cfg.BuildOutputRelativeTo {
+	case "", constants.ServiceRelative:
+		base = serviceDirectoryRoot
+		outputDirectory = filepath.Join(base, outputDirectory)
+	case constants.RootRelative:
+		base = cwd
+		outputDirectory = filepath.Join(base, outputDirectory, serviceName)
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf(
+			"Unknown BuildOutputRelativeTo '%s' (expected %s or %s)",
+			cfg.BuildOutputRelativeTo,
+			constants.ServiceRelative,
+			constants.RootRelative,
+		)}
+	}
 
-		os.Remove(buildOutputPath)
+	// The directory is emptied before every build, so it must never hold the sources
+	relativeOutputDirectory, err := filepath.Rel(base, outputDirectory)
 
-		cmd := exec.Command("dotnet", "publish", "-c", "Release", "-o", buildOutputPath)
-		cmd.Dir = cwd
-		cmd.Env = os.Environ()
+	if err != nil || relativeOutputDirectory == "." || strings.HasPrefix(relativeOutputDirectory, "..") {
+		return "", &ConfigError{Reason: fmt.Sprintf("BuildOutputDirectory '%s' has to be a subdirectory of the %s directory", cfg.BuildOutputDirectory, filepath.Base(base))}
+	}
 
-		var output, errOutput bytes.Buffer
-		cmd.Stdout = &output
-		cmd.Stderr = &errOutput
+	return outputDirectory, nil
+}
 
-		if err := cmd.Run(); err != nil {
-			return errOutput.String(), fmt.Errorf("[!] Failed to build service: %v", err.Error())
-		}
+// prepareBuildOutputDirectory empties the build output directory of a service. With
+// IsolatedBuildOutput a new temporary directory is created inside it instead, so
+// concurrent builds never share an output directory.
+func prepareBuildOutputDirectory(cfg *types.K8sDeployerConfig, cwd, serviceDirectoryRoot, serviceName string, dryRun bool) (string, error) {
+	outputDirectory, err := buildOutputDirectory(cfg, cwd, serviceDirectoryRoot, serviceName)
+	if err != nil {
+		return "", err
+	}
 
-		return output.String(), nil
-	} else {
-		return "", fmt.Errorf("[!] Unknown service type: %s", serviceType)
+	if dryRun && cfg.IsolatedBuildOutput {
+		fmt.Printf("[dry-run] Would build into a temporary directory inside: %s\n", outputDirectory)
+
+		return outputDirectory, nil
+	} else if dryRun {
+		fmt.Printf("[dry-run] Would empty the build output directory: %s\n", outputDirectory)
+
+		return outputDirectory, nil
 	}
-}
 
-func buildDockerImage(cwd, dockerImage string) (string, error) {
-	cmd := exec.Command("docker", "build", "-t", dockerImage, ".")
-	cmd.Dir = cwd
+	if !cfg.IsolatedBuildOutput {
+		if err := os.RemoveAll(outputDirectory); err != nil {
+			return "", &BuildError{ServiceName: serviceName, Err: fmt.Errorf("[!] Failed to clean the build output directory: %v", err)}
+		}
+	}
 
-	var output, errOutput bytes.Buffer
+	if err := os.MkdirAll(outputDirectory, 0755); err != nil {
+		return "", &BuildError{ServiceName: serviceName, Err: fmt.Errorf("[!] Failed to create the build output directory: %v", err)}
+	}
 
-	cmd.Stdout = &output
-	cmd.Stderr = &errOutput
+	if !cfg.IsolatedBuildOutput {
+		return outputDirectory, nil
+	}
 
-	if err := cmd.Run(); err != nil {
-		return errOutput.String(), fmt.Errorf("[!] Failed to build Docker image: %v", err)
+	isolatedOutputDirectory, err := os.MkdirTemp(outputDirectory, serviceName+"-")
+	if err != nil {
+		return "", &BuildError{ServiceName: serviceName, Err: fmt.Errorf("[!] Failed to create the build output directory: %v", err)}
 	}
 
-	return output.String(), nil
+	return isolatedOutputDirectory, nil
 }
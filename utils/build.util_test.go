@@ -0,0 +1,536 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const testDeploymentYaml = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: udecrypt-image-service-deployment
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: udecrypt-image-service
+  template:
+    metadata:
+      labels:
+        app: udecrypt-image-service
+    spec:
+      containers:
+        - name: udecrypt-image-service
+          image: udecrypt_image:1.0.1 # bumped by k8s-deployer
+          env:
+            - name: LOG_LEVEL
+              value: debug
+`
+
+const testServiceYaml = `apiVersion: v1
+kind: Service
+metadata:
+  name: udecrypt-image-service
+spec:
+  selector:
+    app: udecrypt-image-service
+  ports:
+    - port: 80
+`
+
+// newTestProject lays out a repository with a single Go service "image" and returns its
+// root directory and config.
+func newTestProject(t *testing.T) (string, *types.K8sDeployerConfig) {
+	t.Helper()
+
+	cwd := t.TempDir()
+	kubernetesDirectory := filepath.Join(cwd, "services", "go", "image", "k8s")
+
+	if err := os.MkdirAll(kubernetesDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"deployment.dev.yaml":  testDeploymentYaml,
+		"deployment.prod.yaml": strings.Replace(testDeploymentYaml, "udecrypt_image:1.0.1", "registry.example.com/udecrypt/udecrypt_image:1.0.1", 1),
+		"service.yaml":         testServiceYaml,
+	} {
+		if err := os.WriteFile(filepath.Join(kubernetesDirectory, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &types.K8sDeployerConfig{
+		DockerImagePrefix: "udecrypt",
+		DockerContainerRegistry: types.DockerRegistry{
+			Prod: "registry.example.com/udecrypt",
+		},
+		KubernetesConfig: types.KubernetesConfig{
+			Directory: types.DirectoryConfig{"Go": "k8s"},
+			Files: types.FileConfig{
+				Dev:  types.EnvironmentFiles{Deployment: "deployment.dev.yaml", Service: "service.yaml"},
+				Prod: types.EnvironmentFiles{Deployment: "deployment.prod.yaml", Service: "service.yaml"},
+			},
+		},
+		ServicesDirectory: types.ServicesDirectory{
+			Root: types.ServicesDirectoryRoot{"Go": "services/go"},
+			All:  types.AllServices{"Go": {"image": "image"}},
+		},
+	}
+
+	return cwd, cfg
+}
+
+// useRecordingRunner swaps in a RecordingRunner for the duration of the test.
+func useRecordingRunner(t *testing.T) *RecordingRunner {
+	t.Helper()
+
+	recorder := &RecordingRunner{}
+	previous := SetCommandRunner(recorder)
+	previousInterval := rolloutPollInterval
+	rolloutPollInterval = 0
+
+	t.Cleanup(func() {
+		SetCommandRunner(previous)
+		rolloutPollInterval = previousInterval
+	})
+
+	return recorder
+}
+
+func readTestFile(t *testing.T, filePath string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestBuildCompilesBuildsImageAndBumpsYaml(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	serviceDirectoryRoot := filepath.Join(cwd, "services", "go", "image")
+	commands := recorder.Commands()
+
+	if len(commands) != 2 {
+		t.Fatalf("expected 2 commands, got %q", recorder.CommandLines())
+	}
+
+	goBuild := commands[0]
+	expectedGoBuild := "go build -o " + filepath.Join(serviceDirectoryRoot, "build", "udecrypt_image")
+
+	if goBuild.String() != expectedGoBuild || goBuild.Dir != serviceDirectoryRoot {
+		t.Errorf("unexpected go build command %q in %s", goBuild.String(), goBuild.Dir)
+	}
+
+	if strings.Join(goBuild.Env, " ") != "GOOS=linux GOARCH=amd64 CGO_ENABLED=0" {
+		t.Errorf("unexpected go build environment %q", goBuild.Env)
+	}
+
+	if commands[1].String() != "docker build -t udecrypt_image:1.0.2 --build-arg BUILD_OUTPUT_DIR=build ." || commands[1].Dir != serviceDirectoryRoot {
+		t.Errorf("unexpected docker command %q in %s", commands[1].String(), commands[1].Dir)
+	}
+
+	if goBuild.Label != "go/image" || commands[1].Label != "go/image" {
+		t.Errorf("expected both commands to be labelled go/image, got %q and %q", goBuild.Label, commands[1].Label)
+	}
+
+	if buildInfo.NewDockerImagePath != "udecrypt_image:1.0.2" || buildInfo.NextVersion != "1.0.2" {
+		t.Errorf("unexpected build info %+v", buildInfo)
+	}
+
+	if buildInfo.PreviousDockerImagePath != "udecrypt_image:1.0.1" {
+		t.Errorf("expected the previous image to be recorded, got %q", buildInfo.PreviousDockerImagePath)
+	}
+
+	expectedYaml := strings.Replace(testDeploymentYaml, "udecrypt_image:1.0.1", "udecrypt_image:1.0.2", 1)
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != expectedYaml {
+		t.Errorf("deployment YAML was not updated in place:\n%s", yaml)
+	}
+}
+
+func TestBuildHonorsExplicitVersion(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "prod", MicroserviceType: "go", Version: "2.0.0-rc.1"}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if buildInfo.NewDockerImagePath != "registry.example.com/udecrypt/udecrypt_image:2.0.0-rc.1" {
+		t.Errorf("unexpected image %q", buildInfo.NewDockerImagePath)
+	}
+}
+
+func TestBuildLeavesYamlAloneWhenDockerBuildFails(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("docker build", RecordedOutput{Stderr: "no Dockerfile", Err: errors.New("exit status 1")})
+
+	_, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected a *BuildError, got %v", err)
+	}
+
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+
+	if yaml := readTestFile(t, deploymentYamlPath); yaml != testDeploymentYaml {
+		t.Errorf("deployment YAML changed after a failed build:\n%s", yaml)
+	}
+}
+
+func TestBuildReportsUnknownServices(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+
+	_, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "missing")
+
+	var notFoundErr *ServiceNotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected a *ServiceNotFoundError, got %v", err)
+	}
+
+	if len(recorder.Commands()) != 0 {
+		t.Errorf("expected no commands, got %q", recorder.CommandLines())
+	}
+}
+
+func TestBuildDryRunWritesNothing(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+
+	var planned strings.Builder
+	SetCommandRunner(&DryRunRunner{Out: &planned, Queries: recorder})
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go", DryRun: true}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if buildInfo.NewDockerImagePath != "udecrypt_image:1.0.2" {
+		t.Errorf("unexpected image %q", buildInfo.NewDockerImagePath)
+	}
+
+	if !strings.Contains(planned.String(), "[dry-run] $ docker build -t udecrypt_image:1.0.2 --build-arg BUILD_OUTPUT_DIR=build .") {
+		t.Errorf("docker build missing from the plan:\n%s", planned.String())
+	}
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != testDeploymentYaml {
+		t.Errorf("deployment YAML changed during a dry run:\n%s", yaml)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if !strings.HasPrefix(line, "git ") {
+			t.Errorf("only the read-only queries should run in a dry run, got %q", line)
+		}
+	}
+}
+
+func TestBuildDryRunPlansWithTheRealVersion(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Versioning: types.VersioningConfig{Strategy: constants.GitShaVersioning}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("git rev-parse --short HEAD", RecordedOutput{Stdout: "abc1234\n"})
+
+	var planned strings.Builder
+	SetCommandRunner(&DryRunRunner{Out: &planned, Queries: recorder})
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go", DryRun: true}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if buildInfo.NewDockerImagePath != "udecrypt_image:abc1234" {
+		t.Errorf("the plan does not use the version git reports, got %q", buildInfo.NewDockerImagePath)
+	}
+
+	if !strings.Contains(planned.String(), "[dry-run] $ docker build -t udecrypt_image:abc1234 ") {
+		t.Errorf("the planned docker build does not use the git version:\n%s", planned.String())
+	}
+
+	if strings.Contains(planned.String(), "[dry-run] $ git") {
+		t.Errorf("read-only queries should run, not be printed:\n%s", planned.String())
+	}
+}
+
+func TestBuildReusesTheImageWhenTheVersionIsKept(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("git describe", RecordedOutput{Stdout: "v1.0.1\n"})
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if !buildInfo.Cached || buildInfo.NewDockerImagePath != "udecrypt_image:1.0.1" {
+		t.Errorf("expected the released image to be reused, got %+v", buildInfo)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if !strings.HasPrefix(line, "git ") {
+			t.Errorf("nothing should be rebuilt under the released tag, got %q", line)
+		}
+	}
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != testDeploymentYaml {
+		t.Errorf("deployment YAML changed although the version was kept:\n%s", yaml)
+	}
+}
+
+func TestBuildCleansTheConfiguredOutputDirectory(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.BuildOutputDirectory = "out/bin"
+	cfg.BuildOutputRelativeTo = "root"
+	recorder := useRecordingRunner(t)
+
+	// Relative to the root the output is outside the default context, the service directory
+	_, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), "outside its Docker context") {
+		t.Fatalf("expected a *ConfigError for an output directory outside the context, got %v", err)
+	}
+
+	cfg.Services = map[string]types.ServiceConfig{"image": {Docker: types.DockerConfig{Context: ".", ContextRelativeTo: "root"}}}
+	commands := len(recorder.Commands())
+
+	outputDirectory := filepath.Join(cwd, "out", "bin", "image")
+	stale := filepath.Join(outputDirectory, "publish", "stale.dll")
+
+	if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if fileExists(stale) {
+		t.Errorf("stale build output survived the build")
+	}
+
+	if goBuild := recorder.CommandLines()[commands]; goBuild != "go build -o "+filepath.Join(outputDirectory, "udecrypt_image") {
+		t.Errorf("unexpected go build command %q", goBuild)
+	}
+
+	if dockerBuild := recorder.CommandLines()[commands+1]; !strings.Contains(dockerBuild, "--build-arg BUILD_OUTPUT_DIR=out/bin/image ") {
+		t.Errorf("expected the output directory relative to the context, got %q", dockerBuild)
+	}
+}
+
+func TestBuildIsolatesOutputPerBuild(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.IsolatedBuildOutput = true
+	recorder := useRecordingRunner(t)
+
+	if _, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	commands := recorder.Commands()
+	goOutput := commands[0].Args[2]
+	isolatedDirectory := filepath.Dir(goOutput)
+
+	if filepath.Dir(isolatedDirectory) != filepath.Join(cwd, "services", "go", "image", "build") {
+		t.Errorf("expected a temporary directory inside the output directory, got %s", isolatedDirectory)
+	}
+
+	expectedBuildArg := "BUILD_OUTPUT_DIR=build/" + filepath.Base(isolatedDirectory)
+
+	if !strings.Contains(commands[1].String(), "--build-arg "+expectedBuildArg) {
+		t.Errorf("expected %s to be passed to docker, got %q", expectedBuildArg, commands[1].String())
+	}
+
+	if fileExists(isolatedDirectory) {
+		t.Errorf("temporary output directory %s was not removed", isolatedDirectory)
+	}
+}
+
+func TestBuildRejectsOutputDirectoryOutsideTheService(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.BuildOutputDirectory = ".."
+	useRecordingRunner(t)
+
+	_, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a *ConfigError, got %v", err)
+	}
+}
+
+func TestBuildPushesMultiPlatformImagesWithBuildx(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Platforms: []string{"linux/amd64", "linux/arm64"}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	args := &types.Args{DeployTo: "prod", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if err := DeployAfterBuild(cfg, args, buildInfo, "image"); err != nil {
+		t.Fatalf("DeployAfterBuild returned an error: %v", err)
+	}
+
+	outputDirectory := filepath.Join(cwd, "services", "go", "image", "build")
+	lines := recorder.CommandLines()
+
+	expected := []string{
+		"go build -o " + filepath.Join(outputDirectory, "linux_amd64", "udecrypt_image"),
+		"go build -o " + filepath.Join(outputDirectory, "linux_arm64", "udecrypt_image"),
+		"docker buildx build --platform linux/amd64,linux/arm64 -t registry.example.com/udecrypt/udecrypt_image:1.0.2 --build-arg BUILD_OUTPUT_DIR=build --push .",
+	}
+
+	if !reflect.DeepEqual(lines[:3], expected) {
+		t.Errorf("unexpected build commands:\n%s", strings.Join(lines, "\n"))
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "docker push") {
+			t.Errorf("the multi-platform image was pushed again: %s", line)
+		}
+	}
+}
+
+func TestBuildLoadsTheNodePlatformForLocalClusters(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Platforms: []string{"linux/amd64", "linux/arm64"}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get nodes", RecordedOutput{Stdout: "linux/arm64"})
+
+	if _, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	commands := recorder.Commands()
+	goBuild, dockerBuild := commands[1], commands[2]
+
+	if !strings.Contains(strings.Join(goBuild.Env, " "), "GOARCH=arm64") || !strings.Contains(goBuild.String(), "linux_arm64") {
+		t.Errorf("expected an arm64 Go build, got %q with %q", goBuild.String(), goBuild.Env)
+	}
+
+	if dockerBuild.String() != "docker buildx build --platform linux/arm64 -t udecrypt_image:1.0.2 --build-arg BUILD_OUTPUT_DIR=build --load ." {
+		t.Errorf("unexpected docker command %q", dockerBuild.String())
+	}
+}
+
+func TestBuildRejectsPlatformsNotMatchingTheCluster(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Platforms: []string{"linux/amd64"}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get nodes", RecordedOutput{Stdout: "linux/arm64"})
+
+	_, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a *ConfigError, got %v", err)
+	}
+
+	// A dry run reads the nodes as well instead of assuming the first platform
+	SetCommandRunner(&DryRunRunner{Out: &strings.Builder{}, Queries: recorder})
+
+	_, err = Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go", DryRun: true}, cwd, "image")
+
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a *ConfigError from the dry run, got %v", err)
+	}
+}
+
+func TestBuildAppliesDockerOptionsAndEnvironmentOverrides(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Docker: types.DockerConfig{
+		ContextRelativeTo: "root",
+		Dockerfile:        "docker/Dockerfile",
+		BuildArgs:         map[string]string{"VERSION": "{{.Version}}", "COMMIT": "{{.GitSha}}"},
+		Target:            "runtime",
+		Secrets:           []string{"id=npmrc,src=/home/ci/.npmrc"},
+		Tags:              []string{"latest"},
+		Environments: map[string]types.DockerConfig{
+			"prod": {
+				Target:    "release",
+				BuildArgs: map[string]string{"MODE": "{{.Environment}}"},
+				Tags:      []string{"{{.Version}}-{{.GitSha}}"},
+			},
+		},
+	}}}
+	recorder := useRecordingRunner(t)
+	recorder.On("git rev-parse", RecordedOutput{Stdout: "abc1234\n"})
+
+	args := &types.Args{DeployTo: "prod", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	var dockerBuild Command
+
+	for _, cmd := range recorder.Commands() {
+		if cmd.Name == "docker" {
+			dockerBuild = cmd
+		}
+	}
+
+	image := "registry.example.com/udecrypt/udecrypt_image"
+	expected := "docker build -t " + image + ":1.0.2 -t " + image + ":latest -t " + image + ":1.0.2-abc1234" +
+		" -f " + filepath.Join(cwd, "services", "go", "image", "docker", "Dockerfile") +
+		" --target release --build-arg BUILD_OUTPUT_DIR=services/go/image/build --build-arg COMMIT=abc1234 --build-arg MODE=prod --build-arg VERSION=1.0.2" +
+		" --secret id=npmrc,src=/home/ci/.npmrc " + cwd
+
+	if dockerBuild.String() != expected {
+		t.Errorf("unexpected docker command:\n%s\nexpected:\n%s", dockerBuild.String(), expected)
+	}
+
+	if !reflect.DeepEqual(dockerBuild.Env, []string{"DOCKER_BUILDKIT=1"}) {
+		t.Errorf("expected BuildKit for secret mounts, got %q", dockerBuild.Env)
+	}
+
+	recorder.On("kubectl get deployment", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	if err := DeployAfterBuild(cfg, args, buildInfo, "image"); err != nil {
+		t.Fatalf("DeployAfterBuild returned an error: %v", err)
+	}
+
+	var pushed []string
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "docker push ") {
+			pushed = append(pushed, strings.TrimPrefix(line, "docker push "))
+		}
+	}
+
+	if expectedPushes := []string{image + ":1.0.2", image + ":latest", image + ":1.0.2-abc1234"}; !reflect.DeepEqual(pushed, expectedPushes) {
+		t.Errorf("unexpected pushes %q", pushed)
+	}
+}
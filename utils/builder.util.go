@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// BuildContext is everything a Builder gets to know about the service it compiles.
+type BuildContext struct {
+	Cfg                  *types.K8sDeployerConfig
+	ServiceConfig        types.ServiceConfig
+	ServiceName          string
+	ServiceType          string
+	FullServiceName      string
+	ServiceDirectoryRoot string
+	OutputDirectory      string // where the build artifacts have to end up
+	ArtifactName         string // file name for single-binary outputs, e.g. "udecrypt_image"
+	Version              string // version of the image being built
+	DryRun               bool   // commands are only printed, nothing on disk may change
+	Platform             *Platform
+}
+
+// Builder compiles a service of one service type before its Docker image is built.
+type Builder interface {
+	Build(ctx *BuildContext) (string, error)
+}
+
+// PlatformBuilder is implemented by builders whose artifacts depend on the target platform.
+// For services with Platforms they run once per platform, with ctx.Platform set and
+// ctx.OutputDirectory pointing at the platform's subdirectory (see Platform.DirectoryName).
+type PlatformBuilder interface {
+	Builder
+	BuildsPerPlatform() bool
+}
+
+var (
+	buildersMutex sync.RWMutex
+	builders      = map[string]Builder{}
+)
+
+// RegisterBuilder makes a builder available for a service type, replacing any builder
+// registered for it before. Service types are matched case-insensitively, so "Go" in the
+// config and `--type go` refer to the same builder.
+func RegisterBuilder(serviceType string, builder Builder) {
+	buildersMutex.Lock()
+	defer buildersMutex.Unlock()
+
+	builders[strings.ToLower(serviceType)] = builder
+}
+
+// GetBuilder returns the builder registered for a service type.
+func GetBuilder(serviceType string) (Builder, error) {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+
+	builder, found := builders[strings.ToLower(serviceType)]
+
+	if !found {
+		return nil, &UnknownServiceTypeError{ServiceType: serviceType, Registered: registeredServiceTypes()}
+	}
+
+	return builder, nil
+}
+
+// RegisteredServiceTypes lists the service types that have a builder, sorted.
+func RegisteredServiceTypes() []string {
+	buildersMutex.RLock()
+	defer buildersMutex.RUnlock()
+
+	return registeredServiceTypes()
+}
+
+func registeredServiceTypes() []string {
+	serviceTypes := make([]string, 0, len(builders))
+
+	for serviceType := range builders {
+		serviceTypes = append(serviceTypes, serviceType)
+	}
+
+	sort.Strings(serviceTypes)
+
+	return serviceTypes
+}
+
+// runBuildCommand runs a toolchain command in the service directory with the service's
+// extra build environment and returns its output, or its error output when it fails.
+func runBuildCommand(ctx *BuildContext, env []string, name string, args ...string) (string, error) {
+	cmd := Command{Name: name, Args: args, Dir: ctx.ServiceDirectoryRoot, Env: env, Label: serviceLabel(ctx.ServiceType, ctx.ServiceName)}
+
+	for _, key := range sortedKeys(ctx.ServiceConfig.Build.Env) {
+		cmd.Env = append(cmd.Env, key+"="+ctx.ServiceConfig.Build.Env[key])
+	}
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return errOutput, fmt.Errorf("[!] Failed to build service: `%s`: %v", cmd.String(), err)
+	}
+
+	return output, nil
+}
+
+func sortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// lookupByType finds the entry of a map keyed by service type, ignoring case.
+func lookupByType[T any](values map[string]T, serviceType string) (T, bool) {
+	if value, found := values[serviceType]; found {
+		return value, true
+	}
+
+	for key, value := range values {
+		if strings.EqualFold(key, serviceType) {
+			return value, true
+		}
+	}
+
+	var zero T
+
+	return zero, false
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+
+	return err == nil
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// buildClock stamps the build time injected into binaries; tests pin it.
+var buildClock = time.Now
+
+func init() {
+	RegisterBuilder(constants.Go, goBuilder{})
+	RegisterBuilder(constants.Dotnet, dotnetBuilder{})
+	RegisterBuilder(constants.Node, nodeBuilder{})
+	RegisterBuilder(constants.Python, pythonBuilder{})
+	RegisterBuilder(constants.Rust, rustBuilder{})
+	RegisterBuilder(constants.Java, javaBuilder{})
+	RegisterBuilder(constants.Shell, shellBuilder{})
+}
+
+// Builds a binary named after the service, a static linux/amd64 one unless configured otherwise
+type goBuilder struct{}
+
+func (goBuilder) Build(ctx *BuildContext) (string, error) {
+	goConfig := ctx.ServiceConfig.Build.Go
+
+	goos, goarch, cgoEnabled := goConfig.GOOS, goConfig.GOARCH, "0"
+	env := []string{}
+
+	if ctx.Platform != nil {
+		goos, goarch = ctx.Platform.OS, ctx.Platform.Arch
+
+		if ctx.Platform.Arch == "arm" && ctx.Platform.Variant != "" {
+			env = append(env, "GOARM="+strings.TrimPrefix(ctx.Platform.Variant, "v"))
+		}
+	}
+
+	if goos == "" {
+		goos = "linux"
+	}
+
+	if goarch == "" {
+		goarch = "amd64"
+	}
+
+	if goConfig.CGOEnabled {
+		cgoEnabled = "1"
+	}
+
+	fmt.Printf("[+] Building Go binary (%s/%s) for the %s...\n", goos, goarch, ctx.FullServiceName)
+
+	args := []string{"build"}
+
+	if goConfig.TrimPath {
+		args = append(args, "-trimpath")
+	}
+
+	if len(goConfig.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(goConfig.Tags, ","))
+	}
+
+	if ldflags := goLDFlags(ctx, goConfig); ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+
+	args = append(args, "-o", path.Join(ctx.OutputDirectory, ctx.ArtifactName))
+	args = append(args, ctx.ServiceConfig.Build.Args...)
+
+	if goConfig.Package != "" {
+		args = append(args, goConfig.Package)
+	}
+
+	return runBuildCommand(
+		ctx,
+		append([]string{"GOOS=" + goos, "GOARCH=" + goarch, "CGO_ENABLED=" + cgoEnabled}, env...),
+		"go", args...,
+	)
+}
+
+func (goBuilder) BuildsPerPlatform() bool {
+	return true
+}
+
+// goLDFlags appends the configured -X injections of the version, commit and build time to
+// the service's own ldflags.
+func goLDFlags(ctx *BuildContext, goConfig types.GoBuildConfig) string {
+	ldflags := []string{}
+
+	if goConfig.LDFlags != "" {
+		ldflags = append(ldflags, goConfig.LDFlags)
+	}
+
+	if goConfig.VersionVariable != "" {
+		ldflags = append(ldflags, "-X", goConfig.VersionVariable+"="+ctx.Version)
+	}
+
+	if goConfig.CommitVariable != "" {
+		commit, err := gitShortSha(ctx.ServiceDirectoryRoot)
+
+		if err != nil {
+			fmt.Printf("[!] Failed to read the git commit, injecting 'unknown': %v\n", err)
+			commit = "unknown"
+		}
+
+		ldflags = append(ldflags, "-X", goConfig.CommitVariable+"="+commit)
+	}
+
+	if goConfig.BuildTimeVariable != "" {
+		ldflags = append(ldflags, "-X", goConfig.BuildTimeVariable+"="+buildClock().UTC().Format(time.RFC3339))
+	}
+
+	return strings.Join(ldflags, " ")
+}
+
+// Publishes a Release build of the .NET project
+type dotnetBuilder struct{}
+
+func (dotnetBuilder) Build(ctx *BuildContext) (string, error) {
+	fmt.Printf("[+] Building .NET binary for the '%s'...\n", ctx.FullServiceName)
+
+	return runBuildCommand(
+		ctx,
+		nil,
+		"dotnet", append([]string{"publish", "-c", "Release", "-o", ctx.OutputDirectory}, ctx.ServiceConfig.Build.Args...)...,
+	)
+}
+
+// Installs the dependencies and runs the `build` script with npm, pnpm or yarn
+type nodeBuilder struct{}
+
+func (nodeBuilder) Build(ctx *BuildContext) (string, error) {
+	tool := ctx.ServiceConfig.Build.Tool
+
+	if tool == "" {
+		switch {
+		case fileExists(path.Join(ctx.ServiceDirectoryRoot, "pnpm-lock.yaml")):
+			tool = "pnpm"
+		case fileExists(path.Join(ctx.ServiceDirectoryRoot, "yarn.lock")):
+			tool = "yarn"
+		default:
+			tool = "npm"
+		}
+	}
+
+	var installArgs []string
+
+	switch tool {
+	case "npm":
+		installArgs = []string{"install"}
+
+		if fileExists(path.Join(ctx.ServiceDirectoryRoot, "package-lock.json")) {
+			installArgs = []string{"ci"}
+		}
+	case "pnpm", "yarn":
+		installArgs = []string{"install", "--frozen-lockfile"}
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf("Unknown Node package manager '%s' (expected npm, pnpm or yarn)", tool)}
+	}
+
+	fmt.Printf("[+] Building Node project for the '%s' with %s...\n", ctx.FullServiceName, tool)
+
+	installOutput, err := runBuildCommand(ctx, nil, tool, installArgs...)
+	if err != nil {
+		return installOutput, err
+	}
+
+	buildOutput, err := runBuildCommand(ctx, nil, tool, append([]string{"run", "build"}, ctx.ServiceConfig.Build.Args...)...)
+
+	return installOutput + buildOutput, err
+}
+
+// Builds a wheel into the output directory with pip or poetry
+type pythonBuilder struct{}
+
+func (pythonBuilder) Build(ctx *BuildContext) (string, error) {
+	tool := ctx.ServiceConfig.Build.Tool
+
+	if tool == "" {
+		tool = "pip"
+
+		if fileExists(path.Join(ctx.ServiceDirectoryRoot, "poetry.lock")) {
+			tool = "poetry"
+		}
+	}
+
+	fmt.Printf("[+] Building Python wheel for the '%s' with %s...\n", ctx.FullServiceName, tool)
+
+	switch tool {
+	case "pip":
+		return runBuildCommand(
+			ctx,
+			nil,
+			"python", append([]string{"-m", "pip", "wheel", ".", "--no-deps", "-w", ctx.OutputDirectory}, ctx.ServiceConfig.Build.Args...)...,
+		)
+	case "poetry":
+		return runBuildCommand(
+			ctx,
+			nil,
+			"poetry", append([]string{"build", "--format", "wheel", "--output", ctx.OutputDirectory}, ctx.ServiceConfig.Build.Args...)...,
+		)
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf("Unknown Python build tool '%s' (expected pip or poetry)", tool)}
+	}
+}
+
+// Builds the release profile with cargo into the output directory
+type rustBuilder struct{}
+
+func (rustBuilder) Build(ctx *BuildContext) (string, error) {
+	fmt.Printf("[+] Building Rust binary for the '%s'...\n", ctx.FullServiceName)
+
+	return runBuildCommand(
+		ctx,
+		nil,
+		"cargo", append([]string{"build", "--release", "--target-dir", ctx.OutputDirectory}, ctx.ServiceConfig.Build.Args...)...,
+	)
+}
+
+// Packages the project with Maven or Gradle, preferring the project's wrapper script
+type javaBuilder struct{}
+
+func (javaBuilder) Build(ctx *BuildContext) (string, error) {
+	tool := ctx.ServiceConfig.Build.Tool
+
+	if tool == "" {
+		tool = "maven"
+
+		if fileExists(path.Join(ctx.ServiceDirectoryRoot, "build.gradle")) ||
+			fileExists(path.Join(ctx.ServiceDirectoryRoot, "build.gradle.kts")) {
+			tool = "gradle"
+		}
+	}
+
+	var command string
+	var args []string
+
+	switch tool {
+	case "maven":
+		command, args = "mvn", []string{"-B", "-DskipTests", "package"}
+
+		if fileExists(path.Join(ctx.ServiceDirectoryRoot, "mvnw")) {
+			command = "./mvnw"
+		}
+	case "gradle":
+		command, args = "gradle", []string{"build", "-x", "test"}
+
+		if fileExists(path.Join(ctx.ServiceDirectoryRoot, "gradlew")) {
+			command = "./gradlew"
+		}
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf("Unknown Java build tool '%s' (expected maven or gradle)", tool)}
+	}
+
+	fmt.Printf("[+] Building Java project for the '%s' with %s...\n", ctx.FullServiceName, tool)
+
+	return runBuildCommand(ctx, nil, command, append(args, ctx.ServiceConfig.Build.Args...)...)
+}
+
+// Runs the service's configured Build.Command through `sh -c`
+type shellBuilder struct{}
+
+func (shellBuilder) Build(ctx *BuildContext) (string, error) {
+	command := strings.TrimSpace(ctx.ServiceConfig.Build.Command)
+
+	if command == "" {
+		return "", &ConfigError{Reason: fmt.Sprintf("No Build.Command configured for the shell service '%s'", ctx.ServiceName)}
+	}
+
+	fmt.Printf("[+] Running the build command for the '%s'...\n", ctx.FullServiceName)
+
+	return runBuildCommand(
+		ctx,
+		[]string{
+			"SERVICE_NAME=" + ctx.ServiceName,
+			"BUILD_OUTPUT_DIR=" + ctx.OutputDirectory,
+			"ARTIFACT_NAME=" + ctx.ArtifactName,
+			"VERSION=" + ctx.Version,
+		},
+		"sh", append([]string{"-c", command, "sh"}, ctx.ServiceConfig.Build.Args...)...,
+	)
+}
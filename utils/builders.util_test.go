@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestGoBuilderAppliesPlatformAndFlags(t *testing.T) {
+	recorder := useRecordingRunner(t)
+	recorder.On("git rev-parse", RecordedOutput{Stdout: "abc1234\n"})
+
+	previousClock := buildClock
+	buildClock = func() time.Time { return time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC) }
+	t.Cleanup(func() { buildClock = previousClock })
+
+	ctx := &BuildContext{
+		ServiceConfig: types.ServiceConfig{Build: types.BuildConfig{
+			Args: []string{"-v"},
+			Go: types.GoBuildConfig{
+				GOARCH:            "arm64",
+				Tags:              []string{"netgo", "osusergo"},
+				TrimPath:          true,
+				LDFlags:           "-s -w",
+				Package:           "./cmd/server",
+				VersionVariable:   "main.version",
+				CommitVariable:    "main.commit",
+				BuildTimeVariable: "main.buildTime",
+			},
+		}},
+		ServiceDirectoryRoot: "/repo/services/go/image",
+		OutputDirectory:      "/repo/services/go/image/build",
+		ArtifactName:         "udecrypt_image",
+		Version:              "1.4.0",
+	}
+
+	if _, err := (goBuilder{}).Build(ctx); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	goBuild := recorder.Commands()[len(recorder.Commands())-1]
+
+	expectedArgs := []string{
+		"build",
+		"-trimpath",
+		"-tags", "netgo,osusergo",
+		"-ldflags", "-s -w -X main.version=1.4.0 -X main.commit=abc1234 -X main.buildTime=2024-03-01T12:30:00Z",
+		"-o", "/repo/services/go/image/build/udecrypt_image",
+		"-v",
+		"./cmd/server",
+	}
+
+	if !reflect.DeepEqual(goBuild.Args, expectedArgs) {
+		t.Errorf("unexpected go build arguments:\n%q\nexpected:\n%q", goBuild.Args, expectedArgs)
+	}
+
+	if expectedEnv := []string{"GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0"}; !reflect.DeepEqual(goBuild.Env, expectedEnv) {
+		t.Errorf("unexpected go build environment %q", goBuild.Env)
+	}
+}
+
+func TestToolchainBuildersPickTheirCommands(t *testing.T) {
+	cases := []struct {
+		name     string
+		builder  Builder
+		files    []string // created in the service directory
+		build    types.BuildConfig
+		expected []string // command lines
+	}{
+		{"dotnet", dotnetBuilder{}, nil, types.BuildConfig{Args: []string{"-r", "linux-x64"}}, []string{"dotnet publish -c Release -o /out -r linux-x64"}},
+		{"npm without a lockfile", nodeBuilder{}, nil, types.BuildConfig{}, []string{"npm install", "npm run build"}},
+		{"npm with a lockfile", nodeBuilder{}, []string{"package-lock.json"}, types.BuildConfig{}, []string{"npm ci", "npm run build"}},
+		{"pnpm", nodeBuilder{}, []string{"pnpm-lock.yaml", "package-lock.json"}, types.BuildConfig{}, []string{"pnpm install --frozen-lockfile", "pnpm run build"}},
+		{"yarn", nodeBuilder{}, []string{"yarn.lock"}, types.BuildConfig{Args: []string{"--prod"}}, []string{"yarn install --frozen-lockfile", "yarn run build --prod"}},
+		{"configured node tool", nodeBuilder{}, []string{"yarn.lock"}, types.BuildConfig{Tool: "npm"}, []string{"npm install", "npm run build"}},
+		{"pip", pythonBuilder{}, nil, types.BuildConfig{}, []string{"python -m pip wheel . --no-deps -w /out"}},
+		{"poetry", pythonBuilder{}, []string{"poetry.lock"}, types.BuildConfig{}, []string{"poetry build --format wheel --output /out"}},
+		{"cargo", rustBuilder{}, nil, types.BuildConfig{Args: []string{"--locked"}}, []string{"cargo build --release --target-dir /out --locked"}},
+		{"mvn", javaBuilder{}, nil, types.BuildConfig{}, []string{"mvn -B -DskipTests package"}},
+		{"mvnw", javaBuilder{}, []string{"mvnw"}, types.BuildConfig{}, []string{"./mvnw -B -DskipTests package"}},
+		{"gradle", javaBuilder{}, []string{"build.gradle.kts"}, types.BuildConfig{}, []string{"gradle build -x test"}},
+		{"gradlew", javaBuilder{}, []string{"build.gradle", "gradlew"}, types.BuildConfig{}, []string{"./gradlew build -x test"}},
+	}
+
+	for _, c := range cases {
+		recorder := useRecordingRunner(t)
+		serviceDirectoryRoot := t.TempDir()
+
+		for _, file := range c.files {
+			writeTestFile(t, filepath.Join(serviceDirectoryRoot, file), "")
+		}
+
+		ctx := &BuildContext{
+			ServiceConfig:        types.ServiceConfig{Build: c.build},
+			ServiceName:          "image",
+			ServiceDirectoryRoot: serviceDirectoryRoot,
+			OutputDirectory:      "/out",
+		}
+
+		if _, err := c.builder.Build(ctx); err != nil {
+			t.Errorf("%s: Build returned an error: %v", c.name, err)
+			continue
+		}
+
+		if lines := recorder.CommandLines(); !reflect.DeepEqual(lines, c.expected) {
+			t.Errorf("%s: got %q, expected %q", c.name, lines, c.expected)
+		}
+
+		for _, cmd := range recorder.Commands() {
+			if cmd.Dir != serviceDirectoryRoot {
+				t.Errorf("%s: %q ran in %s", c.name, cmd.String(), cmd.Dir)
+			}
+		}
+	}
+}
+
+func TestToolchainBuildersRejectUnknownTools(t *testing.T) {
+	useRecordingRunner(t)
+
+	for _, builder := range []Builder{nodeBuilder{}, pythonBuilder{}, javaBuilder{}} {
+		ctx := &BuildContext{ServiceConfig: types.ServiceConfig{Build: types.BuildConfig{Tool: "make"}}, ServiceDirectoryRoot: t.TempDir()}
+
+		var configErr *ConfigError
+		if _, err := builder.Build(ctx); !errors.As(err, &configErr) || !strings.Contains(err.Error(), "'make'") {
+			t.Errorf("%T: expected a *ConfigError naming the tool, got %v", builder, err)
+		}
+	}
+}
+
+func TestShellBuilderRunsTheCommandWithTheBuildEnvironment(t *testing.T) {
+	recorder := useRecordingRunner(t)
+
+	ctx := &BuildContext{
+		ServiceConfig: types.ServiceConfig{Build: types.BuildConfig{
+			Command: " make image ",
+			Args:    []string{"release"},
+			Env:     map[string]string{"REGION": "eu", "CC": "clang"},
+		}},
+		ServiceName:          "image",
+		ServiceDirectoryRoot: "/repo/services/shell/image",
+		OutputDirectory:      "/repo/services/shell/image/build",
+		ArtifactName:         "udecrypt_image",
+		Version:              "1.4.0",
+	}
+
+	if _, err := (shellBuilder{}).Build(ctx); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	cmd := recorder.Commands()[0]
+
+	if expectedArgs := []string{"-c", "make image", "sh", "release"}; cmd.Name != "sh" || !reflect.DeepEqual(cmd.Args, expectedArgs) {
+		t.Errorf("unexpected command %q", cmd.String())
+	}
+
+	expectedEnv := []string{
+		"SERVICE_NAME=image",
+		"BUILD_OUTPUT_DIR=/repo/services/shell/image/build",
+		"ARTIFACT_NAME=udecrypt_image",
+		"VERSION=1.4.0",
+		// Build.Env, sorted by key
+		"CC=clang",
+		"REGION=eu",
+	}
+
+	if !reflect.DeepEqual(cmd.Env, expectedEnv) {
+		t.Errorf("unexpected environment:\n%q\nexpected:\n%q", cmd.Env, expectedEnv)
+	}
+
+	ctx.ServiceConfig.Build.Command = " "
+
+	var configErr *ConfigError
+	if _, err := (shellBuilder{}).Build(ctx); !errors.As(err, &configErr) {
+		t.Errorf("expected a *ConfigError without a Build.Command, got %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Never part of a service's source, whatever the ignore files say
+var alwaysIgnoredDirectories = []string{".git", stateDirectory}
+
+// sourceInputs is everything a service's source hash is computed over.
+type sourceInputs struct {
+	ServiceDirectoryRoot string
+	DockerContext        string   // the .dockerignore of the context applies
+	ExcludedDirectories  []string // absolute, e.g. the build output and the Kubernetes directory
+	Cache                types.CacheConfig
+	Settings             any      // build settings that change the image without changing a file
+	ProjectRoot          string   // SharedPaths are relative to it
+	SharedPaths          []string // paths or globs outside the service directory it is built from
+}
+
+// sourceHash hashes the service's source tree: the path, mode and content of every file
+// that is not ignored by the Docker context's .dockerignore or the service's Exclude
+// globs, and matches its Include globs when there are any. A Docker context reaching outside
+// the service directory is hashed as a whole, minus its .dockerignore, since any of its
+// files can end up in the image. The files matching the shared paths are hashed too, since
+// a change under them selects the service for a rebuild.
+func sourceHash(inputs sourceInputs) (string, error) {
+	ignorePatterns, err := readIgnoreFile(filepath.Join(inputs.DockerContext, ".dockerignore"))
+	if err != nil {
+		return "", err
+	}
+
+	var files []string
+
+	err = filepath.WalkDir(inputs.ServiceDirectoryRoot, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			for _, excluded := range inputs.ExcludedDirectories {
+				if filePath == excluded {
+					return filepath.SkipDir
+				}
+			}
+
+			for _, ignored := range alwaysIgnoredDirectories {
+				if entry.Name() == ignored {
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		serviceRelative := filepath.ToSlash(mustRel(inputs.ServiceDirectoryRoot, filePath))
+		contextRelative := filepath.ToSlash(mustRel(inputs.DockerContext, filePath))
+
+		if ignoredByPatterns(ignorePatterns, contextRelative) || matchesAnyGlob(inputs.Cache.Exclude, serviceRelative) {
+			return nil
+		}
+
+		if len(inputs.Cache.Include) > 0 && !matchesAnyGlob(inputs.Cache.Include, serviceRelative) {
+			return nil
+		}
+
+		files = append(files, filePath)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to hash the service sources: %v", err)
+	}
+
+	sort.Strings(files)
+
+	contextFiles, err := contextFilesOutsideService(inputs, ignorePatterns)
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to hash the Docker context: %v", err)
+	}
+
+	hash := sha256.New()
+
+	settings, err := json.Marshal(inputs.Settings)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(hash, "settings %s\n", settings)
+
+	for _, filePath := range files {
+		if err := hashFile(hash, inputs.ServiceDirectoryRoot, filePath); err != nil {
+			return "", fmt.Errorf("[!] Failed to hash the service sources: %v", err)
+		}
+	}
+
+	for _, filePath := range contextFiles {
+		fmt.Fprint(hash, "context ")
+
+		if err := hashFile(hash, inputs.DockerContext, filePath); err != nil {
+			return "", fmt.Errorf("[!] Failed to hash the Docker context: %v", err)
+		}
+	}
+
+	shared, err := sharedFiles(inputs.ProjectRoot, inputs.SharedPaths)
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to hash the shared paths: %v", err)
+	}
+
+	for _, filePath := range shared {
+		fmt.Fprint(hash, "shared ")
+
+		if err := hashFile(hash, inputs.ProjectRoot, filePath); err != nil {
+			return "", fmt.Errorf("[!] Failed to hash the shared paths: %v", err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contextFilesOutsideService lists the files of the Docker context that are outside the
+// service directory and not ignored by its .dockerignore. There are none when the context
+// is the service directory or inside it.
+func contextFilesOutsideService(inputs sourceInputs, ignorePatterns []string) ([]string, error) {
+	if isWithinDirectory(inputs.ServiceDirectoryRoot, inputs.DockerContext) {
+		return nil, nil
+	}
+
+	var files []string
+
+	err := filepath.WalkDir(inputs.DockerContext, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if filePath == inputs.ServiceDirectoryRoot {
+				return filepath.SkipDir
+			}
+
+			for _, excluded := range inputs.ExcludedDirectories {
+				if filePath == excluded {
+					return filepath.SkipDir
+				}
+			}
+
+			for _, ignored := range alwaysIgnoredDirectories {
+				if entry.Name() == ignored {
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		if !ignoredByPatterns(ignorePatterns, filepath.ToSlash(mustRel(inputs.DockerContext, filePath))) {
+			files = append(files, filePath)
+		}
+
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+// isWithinDirectory tells whether target is the directory itself or inside it.
+func isWithinDirectory(directory, target string) bool {
+	relative, err := filepath.Rel(directory, target)
+
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// sharedFiles lists the files of the project matching the shared paths, with the same
+// matching as AffectedServices: a path or glob matches a file or any of its parent
+// directories.
+func sharedFiles(projectRoot string, sharedPaths []string) ([]string, error) {
+	if len(sharedPaths) == 0 {
+		return nil, nil
+	}
+
+	patterns := make([]string, len(sharedPaths))
+
+	for i, sharedPath := range sharedPaths {
+		patterns[i] = path.Clean(strings.TrimPrefix(filepath.ToSlash(sharedPath), "/"))
+	}
+
+	var files []string
+
+	err := filepath.WalkDir(projectRoot, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			for _, ignored := range alwaysIgnoredDirectories {
+				if entry.Name() == ignored {
+					return filepath.SkipDir
+				}
+			}
+
+			return nil
+		}
+
+		relativePath := filepath.ToSlash(mustRel(projectRoot, filePath))
+
+		for _, pattern := range patterns {
+			if matchesPathOrParent(pattern, relativePath) {
+				files = append(files, filePath)
+				break
+			}
+		}
+
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+func hashFile(hash io.Writer, root, filePath string) error {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(hash, "file %s %o\n", filepath.ToSlash(mustRel(root, filePath)), info.Mode())
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "link %s\n", target)
+
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	fmt.Fprintf(hash, "size %d\n", info.Size())
+	_, err = io.Copy(hash, file)
+
+	return err
+}
+
+func mustRel(base, target string) string {
+	relative, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+
+	return relative
+}
+
+// readIgnoreFile reads the patterns of a .dockerignore file, if there is one.
+func readIgnoreFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// ignoredByPatterns applies .dockerignore semantics: a pattern matches a path or any of
+// its parent directories, `!` re-includes, and the last matching pattern wins.
+func ignoredByPatterns(patterns []string, relativePath string) bool {
+	ignored := false
+
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = path.Clean(strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/"))
+
+		if matchesPathOrParent(pattern, relativePath) {
+			ignored = !negated
+		}
+	}
+
+	return ignored
+}
+
+// matchesPathOrParent tells whether a glob matches a path or any of its parent directories.
+func matchesPathOrParent(pattern, relativePath string) bool {
+	for candidate := relativePath; candidate != "." && candidate != "" && candidate != "/"; candidate = path.Dir(candidate) {
+		if matchGlob(pattern, candidate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAnyGlob(patterns []string, relativePath string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(strings.TrimPrefix(pattern, "/"), relativePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob where `**` matches any number of
+// path segments and every other segment is matched with path.Match.
+func matchGlob(pattern, relativePath string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(relativePath, "/"))
+}
+
+func matchGlobSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(segments); skip++ {
+				if matchGlobSegments(pattern[1:], segments[skip:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+			return false
+		}
+
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+// lookupCachedBuild hashes the service's sources and returns the hash, along with the
+// previous build when it can be reused: the sources and build settings are unchanged and
+// the deployment YAML still references its image. --force, --version and --bump always
+// rebuild.
+func lookupCachedBuild(
+	cfg *types.K8sDeployerConfig,
+	env *types.EnvironmentConfig,
+	serviceConfig types.ServiceConfig,
+	args *types.Args,
+	cwd, serviceDirectoryRoot, kubernetesDirectory, serviceName, currentImage string,
+) (string, *ServiceBuildState, error) {
+	if serviceConfig.Cache.Disabled {
+		return "", nil, nil
+	}
+
+	dockerConfig := resolveDockerConfig(serviceConfig, env)
+
+	context, err := dockerContext(dockerConfig, cwd, serviceDirectoryRoot, serviceName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	outputDirectory, err := buildOutputDirectory(cfg, cwd, serviceDirectoryRoot, serviceName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash, err := sourceHash(sourceInputs{
+		ServiceDirectoryRoot: serviceDirectoryRoot,
+		DockerContext:        context,
+		ExcludedDirectories:  []string{outputDirectory, kubernetesDirectory},
+		Cache:                serviceConfig.Cache,
+		Settings: map[string]any{
+			"Build":     serviceConfig.Build,
+			"Docker":    dockerConfig,
+			"Platforms": serviceConfig.Platforms,
+		},
+		ProjectRoot: cwd,
+		SharedPaths: append(append([]string{}, cfg.SharedPaths...), serviceConfig.SharedPaths...),
+	})
+
+	if err != nil {
+		return "", nil, &BuildError{ServiceName: serviceName, Err: err}
+	}
+
+	if args.Force || args.Version != "" || args.Bump != "" {
+		return hash, nil, nil
+	}
+
+	state, err := loadBuildState(cwd)
+	if err != nil {
+		return "", nil, &BuildError{ServiceName: serviceName, Err: err}
+	}
+
+	previous, found := state.Services[stateKey(env.Name, serviceName)]
+
+	if !found || previous.SourceHash != hash || previous.Image != currentImage {
+		return hash, nil, nil
+	}
+
+	return hash, &previous, nil
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func writeTestFile(t *testing.T, filePath, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildReusesUnchangedSources(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	serviceDirectory := filepath.Join(cwd, "services", "go", "image")
+
+	writeTestFile(t, filepath.Join(serviceDirectory, "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(serviceDirectory, ".dockerignore"), "*.md\n")
+
+	build := func(args *types.Args) *BuildInfo {
+		t.Helper()
+
+		buildInfo, err := Build(cfg, args, cwd, "image")
+		if err != nil {
+			t.Fatalf("Build returned an error: %v", err)
+		}
+
+		return buildInfo
+	}
+
+	dev := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	if first := build(dev); first.Cached || first.NewDockerImagePath != "udecrypt_image:1.0.2" {
+		t.Fatalf("expected a fresh build of 1.0.2, got %+v", first)
+	}
+
+	commands := len(recorder.Commands())
+
+	if second := build(dev); !second.Cached || second.NewDockerImagePath != "udecrypt_image:1.0.2" {
+		t.Errorf("expected the unchanged service to reuse 1.0.2, got %+v", second)
+	}
+
+	writeTestFile(t, filepath.Join(serviceDirectory, "README.md"), "ignored by .dockerignore\n")
+
+	if third := build(dev); !third.Cached {
+		t.Errorf("a .dockerignored change triggered a rebuild")
+	}
+
+	if len(recorder.Commands()) != commands {
+		t.Errorf("cached builds ran commands: %q", recorder.CommandLines()[commands:])
+	}
+
+	writeTestFile(t, filepath.Join(serviceDirectory, "main.go"), "package main\n\nfunc main() {}\n")
+
+	if fourth := build(dev); fourth.Cached || fourth.NewDockerImagePath != "udecrypt_image:1.0.3" {
+		t.Errorf("expected a source change to build 1.0.3, got %+v", fourth)
+	}
+
+	if forced := build(&types.Args{DeployTo: "dev", MicroserviceType: "go", Force: true}); forced.Cached {
+		t.Errorf("--force reused the previous image")
+	}
+}
+
+func TestBuildRebuildsWhenASharedPathChanges(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+
+	writeTestFile(t, filepath.Join(cwd, "services", "go", "image", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(cwd, "pkg", "auth", "auth.go"), "package auth\n")
+	writeTestFile(t, filepath.Join(cwd, "docs", "guide.md"), "not shared\n")
+
+	cfg.SharedPaths = []string{"go.mod"}
+	cfg.Services = map[string]types.ServiceConfig{"image": {SharedPaths: []string{"pkg/"}}}
+
+	dev := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	for i, change := range []struct {
+		path    string
+		rebuilt bool
+	}{
+		{"", true},
+		{"docs/guide.md", false},
+		{"pkg/auth/auth.go", true},
+		{"go.mod", true},
+	} {
+		if change.path != "" {
+			writeTestFile(t, filepath.Join(cwd, change.path), fmt.Sprintf("changed %d\n", i))
+		}
+
+		buildInfo, err := Build(cfg, dev, cwd, "image")
+		if err != nil {
+			t.Fatalf("Build returned an error: %v", err)
+		}
+
+		if buildInfo.Cached == change.rebuilt {
+			t.Errorf("after changing %q expected a rebuild: %v, got cached: %v", change.path, change.rebuilt, buildInfo.Cached)
+		}
+	}
+}
+
+func TestBuildHashesADockerContextOutsideTheServiceDirectory(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+
+	writeTestFile(t, filepath.Join(cwd, "services", "go", "image", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(cwd, "proto", "image.proto"), "syntax = \"proto3\";\n")
+	writeTestFile(t, filepath.Join(cwd, ".dockerignore"), "docs\n")
+
+	cfg.Services = map[string]types.ServiceConfig{"image": {Docker: types.DockerConfig{Context: ".", ContextRelativeTo: constants.RootRelative}}}
+
+	dev := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	for i, change := range []struct {
+		path    string
+		rebuilt bool
+	}{
+		{"", true},
+		{"docs/guide.md", false},
+		{"proto/image.proto", true},
+	} {
+		if change.path != "" {
+			writeTestFile(t, filepath.Join(cwd, change.path), fmt.Sprintf("changed %d\n", i))
+		}
+
+		buildInfo, err := Build(cfg, dev, cwd, "image")
+		if err != nil {
+			t.Fatalf("Build returned an error: %v", err)
+		}
+
+		if buildInfo.Cached == change.rebuilt {
+			t.Errorf("after changing %q expected a rebuild: %v, got cached: %v", change.path, change.rebuilt, buildInfo.Cached)
+		}
+	}
+}
+
+func TestRunPipelineDoesNotRedeployCachedImages(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	args := &types.Args{DeployTo: "dev"}
+	targets := []ServiceTarget{{Name: "image", Type: "go"}}
+
+	if _, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.2"})
+	commands := len(recorder.Commands())
+
+	results, err := RunPipeline(cfg, args, cwd, "bnd", targets, 1)
+	if err != nil {
+		t.Fatalf("RunPipeline returned an error: %v", err)
+	}
+
+	if results[0].Build != stepCached || results[0].Deploy != stepUpToDate {
+		t.Errorf("expected a cached build and an up-to-date deploy, got %s/%s", results[0].Build, results[0].Deploy)
+	}
+
+	for _, line := range recorder.CommandLines()[commands:] {
+		if !strings.Contains(line, "-o jsonpath") {
+			t.Errorf("ran %q for an up-to-date service", line)
+		}
+	}
+}
+
+func TestIgnoredByPatterns(t *testing.T) {
+	patterns := []string{"**/*.md", "docs", "!docs/keep.txt", "/tmp*"}
+
+	cases := map[string]bool{
+		"README.md":          true,
+		"pkg/api/NOTES.md":   true,
+		"docs/guide.txt":     true,
+		"docs/keep.txt":      false,
+		"tmpfile":            true,
+		"pkg/tmpfile":        false,
+		"main.go":            false,
+		"cmd/server/main.go": false,
+	}
+
+	for relativePath, expected := range cases {
+		if ignored := ignoredByPatterns(patterns, relativePath); ignored != expected {
+			t.Errorf("ignoredByPatterns(%q) = %v, expected %v", relativePath, ignored, expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// AffectedService is a service touched by the changes since a git ref, with the changed
+// files that touched it.
+type AffectedService struct {
+	Target       ServiceTarget
+	ChangedFiles []string
+}
+
+// ChangedFiles lists the files, relative to cwd, that differ from the git ref in the
+// working tree, including untracked ones.
+func ChangedFiles(cwd, ref string) ([]string, error) {
+	changed, err := gitOutput(cwd, "diff", "--name-only", "--relative", ref, "--")
+	if err != nil {
+		return nil, &UsageError{Reason: fmt.Sprintf("Failed to list the changes since '%s': %s", ref, strings.TrimPrefix(err.Error(), "[!] "))}
+	}
+
+	untracked, err := gitOutput(cwd, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, &UsageError{Reason: fmt.Sprintf("Failed to list the untracked files: %s", strings.TrimPrefix(err.Error(), "[!] "))}
+	}
+
+	seen := map[string]bool{}
+	var files []string
+
+	for _, file := range strings.Split(changed+"\n"+untracked, "\n") {
+		file = strings.TrimSpace(file)
+
+		if file != "" && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// AffectedServices keeps the targets touched by a changed file: a file inside the service
+// directory, or matching one of the SharedPaths of the config or of the service.
+func AffectedServices(cfg *types.K8sDeployerConfig, targets []ServiceTarget, changedFiles []string) []AffectedService {
+	var affected []AffectedService
+
+	for _, target := range targets {
+		servicesRoot, _ := lookupByType(cfg.ServicesDirectory.Root, target.Type)
+		services, _ := lookupByType(cfg.ServicesDirectory.All, target.Type)
+
+		patterns := []string{path.Join(servicesRoot, services[target.Name])}
+		patterns = append(patterns, cfg.SharedPaths...)
+		patterns = append(patterns, cfg.Services[target.Name].SharedPaths...)
+
+		var files []string
+
+		for _, file := range changedFiles {
+			for _, pattern := range patterns {
+				if matchesPathOrParent(path.Clean(strings.TrimPrefix(pattern, "/")), file) {
+					files = append(files, file)
+					break
+				}
+			}
+		}
+
+		if len(files) > 0 {
+			affected = append(affected, AffectedService{Target: target, ChangedFiles: files})
+		}
+	}
+
+	return affected
+}
+
+// PrintAffectedServices writes the affected set, with up to three changed files each.
+func PrintAffectedServices(w io.Writer, ref string, affected []AffectedService) {
+	if len(affected) == 0 {
+		fmt.Fprintf(w, "[+] No services are affected by the changes since '%s'\n", ref)
+		return
+	}
+
+	fmt.Fprintf(w, "[+] %d service(s) affected by the changes since '%s':\n", len(affected), ref)
+
+	for _, service := range affected {
+		files := service.ChangedFiles
+
+		if len(files) > 3 {
+			files = append(files[:3:3], fmt.Sprintf("and %d more", len(service.ChangedFiles)-3))
+		}
+
+		fmt.Fprintf(w, "    %s (%s): %s\n", service.Target.Name, service.Target.Type, strings.Join(files, ", "))
+	}
+}
+
+// AffectedTargets returns the targets of the affected services.
+func AffectedTargets(affected []AffectedService) []ServiceTarget {
+	targets := make([]ServiceTarget, len(affected))
+
+	for i, service := range affected {
+		targets[i] = service.Target
+	}
+
+	return targets
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestAffectedServices(t *testing.T) {
+	cfg := &types.K8sDeployerConfig{
+		ServicesDirectory: types.ServicesDirectory{
+			Root: types.ServicesDirectoryRoot{"Go": "services/go", "Dotnet": "services/dotnet"},
+			All: types.AllServices{
+				"Go":     {"image": "image", "file": "file", "blockchain": "blockchain"},
+				"Dotnet": {"auth": "Auth.API"},
+			},
+		},
+		SharedPaths: []string{"go.work"},
+		Services: map[string]types.ServiceConfig{
+			"file": {SharedPaths: []string{"pkg/"}},
+			"auth": {SharedPaths: []string{"libs/**/*.cs"}},
+		},
+	}
+
+	targets := []ServiceTarget{
+		{Name: "auth", Type: "dotnet"},
+		{Name: "blockchain", Type: "go"},
+		{Name: "file", Type: "go"},
+		{Name: "image", Type: "go"},
+	}
+
+	cases := []struct {
+		changedFiles []string
+		expected     []AffectedService
+	}{
+		{
+			changedFiles: []string{"services/go/image/main.go", "README.md", "services/go/images/main.go"},
+			expected:     []AffectedService{{Target: targets[3], ChangedFiles: []string{"services/go/image/main.go"}}},
+		},
+		{
+			changedFiles: []string{"pkg/storage/s3.go", "libs/shared/Money.cs"},
+			expected: []AffectedService{
+				{Target: targets[0], ChangedFiles: []string{"libs/shared/Money.cs"}},
+				{Target: targets[2], ChangedFiles: []string{"pkg/storage/s3.go"}},
+			},
+		},
+		{
+			changedFiles: []string{"go.work"},
+			expected: []AffectedService{
+				{Target: targets[0], ChangedFiles: []string{"go.work"}},
+				{Target: targets[1], ChangedFiles: []string{"go.work"}},
+				{Target: targets[2], ChangedFiles: []string{"go.work"}},
+				{Target: targets[3], ChangedFiles: []string{"go.work"}},
+			},
+		},
+		{
+			changedFiles: []string{"docs/index.md"},
+		},
+	}
+
+	for _, c := range cases {
+		if affected := AffectedServices(cfg, targets, c.changedFiles); !reflect.DeepEqual(affected, c.expected) {
+			t.Errorf("AffectedServices(%q) = %+v, expected %+v", c.changedFiles, affected, c.expected)
+		}
+	}
+}
+
+func TestChangedFilesIncludesUntrackedFiles(t *testing.T) {
+	recorder := useRecordingRunner(t)
+	recorder.On("git diff --name-only --relative main", RecordedOutput{Stdout: "services/go/image/main.go\npkg/a.go\n"})
+	recorder.On("git ls-files --others", RecordedOutput{Stdout: "pkg/a.go\nservices/go/file/new.go\n"})
+
+	files, err := ChangedFiles("/repo", "main")
+	if err != nil {
+		t.Fatalf("ChangedFiles returned an error: %v", err)
+	}
+
+	if expected := []string{"pkg/a.go", "services/go/file/new.go", "services/go/image/main.go"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected changed files %q", files)
+	}
+}
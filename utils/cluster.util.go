@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// verifyCluster makes sure kubectl is going to talk to the cluster the environment was
+// configured for, before anything is delivered or applied. The pinned context has to exist
+// in the kubeconfig, and when ClusterServer is set the context has to point at that API
+// server. Without either, kubectl's current context is used and only a warning is printed.
+func verifyCluster(env *types.EnvironmentConfig, cwd string) error {
+	if env.KubeContext == "" && env.ClusterServer == "" {
+		fmt.Printf("[!] Environment '%s' pins no KubeContext, deploying to kubectl's current context\n", env.Name)
+
+		return nil
+	}
+
+	context := env.KubeContext
+
+	if context != "" {
+		cmd := kubectlCommand(&types.EnvironmentConfig{Kubeconfig: env.Kubeconfig}, "config", "get-contexts", "-o", "name")
+		cmd.Dir = cwd
+		cmd.Quiet = true
+
+		output, errOutput, err := runCommand(cmd)
+
+		if err != nil {
+			return &ClusterError{Environment: env.Name, Reason: "failed to list the kube contexts: " + commandError(err, errOutput)}
+		}
+
+		if !containsLine(output, context) {
+			return &ClusterError{Environment: env.Name, Reason: fmt.Sprintf("kube context '%s' is not in the kubeconfig", context)}
+		}
+	} else {
+		cmd := kubectlCommand(&types.EnvironmentConfig{Kubeconfig: env.Kubeconfig}, "config", "current-context")
+		cmd.Dir = cwd
+		cmd.Quiet = true
+
+		output, errOutput, err := runCommand(cmd)
+
+		if err != nil {
+			return &ClusterError{Environment: env.Name, Reason: "failed to read the current kube context: " + commandError(err, errOutput)}
+		}
+
+		context = strings.TrimSpace(output)
+	}
+
+	if env.ClusterServer == "" {
+		fmt.Printf("[+] Using kube context '%s'\n", context)
+
+		return nil
+	}
+
+	cmd := kubectlCommand(
+		&types.EnvironmentConfig{Kubeconfig: env.Kubeconfig},
+		"config", "view", "--minify", "--context", context, "-o", "jsonpath={.clusters[0].cluster.server}",
+	)
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return &ClusterError{Environment: env.Name, Reason: fmt.Sprintf("failed to read the cluster of kube context '%s': %s", context, commandError(err, errOutput))}
+	}
+
+	server := strings.TrimSpace(output)
+
+	if normalizeServer(server) != normalizeServer(env.ClusterServer) {
+		return &ClusterError{Environment: env.Name, Reason: fmt.Sprintf(
+			"kube context '%s' points at %s, expected %s",
+			context,
+			server,
+			env.ClusterServer,
+		)}
+	}
+
+	fmt.Printf("[+] Using kube context '%s' (%s)\n", context, env.ClusterServer)
+
+	return nil
+}
+
+func normalizeServer(server string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(server)), "/")
+}
+
+func containsLine(output, line string) bool {
+	for _, outputLine := range strings.Split(output, "\n") {
+		if strings.TrimSpace(outputLine) == line {
+			return true
+		}
+	}
+
+	return false
+}
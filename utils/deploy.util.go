@@ -1,70 +1,208 @@
 package utils
 
 import (
-	"bytes"
 	"fmt"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
 	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
 )
 
-func deploy(
-	cfg *types.K8sDeployerConfig,
-	cwd,
-	mode,
-	serviceName,
-	dockerImagePath,
-	deploymentFilePath,
-	serviceFilePath string,
-) error {
-	fullServiceName := ParseServiceName(cfg.DockerImagePrefix, serviceName)
-	deleteExistingDeployment(fullServiceName)
+// deployRequest is what deploy rolls out for one service.
+type deployRequest struct {
+	ProjectRoot          string // directory of the config file, where the deploy history is kept
+	ServiceDirectoryRoot string
+	ServiceName          string
+	ServiceType          string
+	Image                string
+	ExtraImages          []string // the image under its extra tags, delivered along with it
+	DeploymentYamlPath   string
+	ServiceYamlPath      string
+	PreviousImage        string // restored when the rollout fails, the live image when empty
+	Action               string // recorded in the deploy history, "deploy" when empty
+	SkipDelivery         bool   // the image reached the cluster in an earlier deploy
+	Diff                 bool   // show the manifests' diff against the cluster before applying
+	Confirm              bool   // ask for approval when the diff changes more than the images
+	DryRun               bool
+}
 
-	fmt.Println("[+] Deployment process started...")
+func deploy(cfg *types.K8sDeployerConfig, env *types.EnvironmentConfig, request deployRequest) error {
+	cwd := request.ServiceDirectoryRoot
+	dockerImagePath := request.Image
+	deploymentFilePath, serviceFilePath := request.DeploymentYamlPath, request.ServiceYamlPath
+	previousDockerImagePath := request.PreviousImage
 
-	var cmd *exec.Cmd
-	var output bytes.Buffer
+	fullServiceName := ParseServiceName(cfg.DockerImagePrefix, request.ServiceName)
+	label := serviceLabel(request.ServiceType, request.ServiceName)
 
-	if mode == constants.Dev {
-		imagePushOutput, err := loadDockerImageToMinikube(cwd, dockerImagePath)
-		if err != nil {
+	serviceConfig, err := GetServiceConfig(cfg, request.ServiceName)
+	if err != nil {
+		return err
+	}
+
+	if err := checkManifestsExist(deploymentFilePath, serviceFilePath); err != nil {
+		return err
+	}
+
+	workload, err := ParseWorkload(cfg, deploymentFilePath, request.ServiceName)
+	if err != nil {
+		return err
+	}
+
+	name := workload.Name
+
+	extraManifests, err := resolveExtraManifests(env, serviceConfig, filepath.Dir(deploymentFilePath), deploymentFilePath, serviceFilePath)
+	if err != nil {
+		return err
+	}
+
+	beforeWorkload, afterWorkload := splitAroundWorkloads(extraManifests)
+	allManifests := append([]string{deploymentFilePath, serviceFilePath}, manifestPaths(extraManifests)...)
+
+	fmt.Printf("[+] Deployment process started (%s environment, %s strategy)...\n", env.Name, serviceConfig.Strategy)
+
+	if err := verifyCluster(env, cwd); err != nil {
+		return err
+	}
+
+	if request.Diff || request.Confirm {
+		if request.DryRun {
+			fmt.Println("[dry-run] Would diff the manifests against the cluster before applying them")
+		} else if err := reviewManifests(env, cwd, request.ServiceName, request.Confirm, allManifests...); err != nil {
 			return err
 		}
+	}
 
-		fmt.Println(imagePushOutput)
-	} else {
-		imagePushOutput, err := pushDockerImageToLive(cwd, dockerImagePath)
-		if err != nil {
-			return err
+	// The image has to be available to the cluster before anything running is touched,
+	// otherwise a failed push would leave the service without pods.
+	switch {
+	case request.SkipDelivery:
+		fmt.Printf("[+] %s was delivered when it was first deployed\n", dockerImagePath)
+	case pushedByBuildx(env, serviceConfig):
+		fmt.Printf("[+] %s was pushed for %s by docker buildx\n", dockerImagePath, strings.Join(serviceConfig.Platforms, ", "))
+	case env.ImageDelivery == constants.MinikubeDelivery:
+		_, err = loadDockerImageToMinikube(cwd, label, dockerImagePath)
+	case env.ImageDelivery == constants.KindDelivery:
+		_, err = loadDockerImageToKind(cwd, label, env.KindCluster, dockerImagePath)
+	default:
+		_, err = pushDockerImagesToLive(cwd, label, append([]string{dockerImagePath}, request.ExtraImages...))
+	}
+
+	if err != nil {
+		return &DeployError{ServiceName: request.ServiceName, Err: err}
+	}
+
+	// From here on the cluster is touched, so the outcome goes into the deploy history
+	record := func(outcome string) {
+		if request.DryRun {
+			return
+		}
+
+		action := request.Action
+		if action == "" {
+			action = deployAction
 		}
 
-		fmt.Println(imagePushOutput)
+		recordDeploy(env, request.ProjectRoot, label, request.ServiceName,
+			newDeployRecord(request.ProjectRoot, dockerImagePath, action, outcome, allManifests...))
 	}
 
-	fmt.Println("[+] Applying deployment YAML file: " + deploymentFilePath)
-	cmd = exec.Command("kubectl", "apply", "-f", deploymentFilePath)
-	cmd.Dir = cwd
-	cmd.Stdout = &output
+	liveImage := getLiveImage(env, cwd, workload)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("[!] Failed to apply deployment YAML file for '%s': %v", fullServiceName, err)
+	if previousDockerImagePath == "" {
+		previousDockerImagePath = liveImage
 	}
 
-	fmt.Println(output.String())
-	output.Reset()
+	if err := applyExtraManifests(env, cwd, label, request.ServiceName, beforeWorkload); err != nil {
+		record(deployFailed)
 
+		return err
+	}
+
+	// The Service ranks before the workloads, so it routes to the new pods as they come up
 	fmt.Println("[+] Applying service YAML file: " + serviceFilePath)
-	cmd = exec.Command("kubectl", "apply", "-f", serviceFilePath)
+	cmd := kubectlCommand(env, "apply", "-f", serviceFilePath)
+	cmd.Dir = cwd
+	cmd.Label = label
+
+	_, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		record(deployFailed)
+
+		return &DeployError{
+			ServiceName: request.ServiceName,
+			Err:         fmt.Errorf("[!] Failed to apply service YAML file for '%s': %s", fullServiceName, commandError(err, errOutput)),
+		}
+	}
+
+	// The pod template of a Job cannot be changed, so a Job is always created again
+	if serviceConfig.Strategy == constants.RecreateStrategy || workload.Kind == jobKind {
+		deleteExistingWorkload(env, label, workload)
+	}
+
+	fmt.Println("[+] Applying deployment YAML file: " + deploymentFilePath)
+	cmd = kubectlCommand(env, "apply", "-f", deploymentFilePath)
 	cmd.Dir = cwd
-	cmd.Stdout = &output
+	cmd.Label = label
+
+	_, errOutput, err = runCommand(cmd)
+
+	if err != nil {
+		record(deployFailed)
+
+		return &DeployError{
+			ServiceName: request.ServiceName,
+			Err:         fmt.Errorf("[!] Failed to apply deployment YAML file for '%s': %s", fullServiceName, commandError(err, errOutput)),
+		}
+	}
+
+	if request.DryRun {
+		fmt.Printf("[dry-run] Would wait up to %s for the rollout of '%s' to %s, and roll back to %s if it fails\n",
+			serviceConfig.RolloutTimeout, name, dockerImagePath, rollbackTarget(previousDockerImagePath))
+	} else if err := waitForRollout(env, cwd, workload, dockerImagePath, serviceConfig.RolloutTimeout); err != nil {
+		fmt.Println(err.Error())
+
+		// Running the previous job again could repeat what the failed one already did
+		if workload.Kind == jobKind {
+			record(deployFailed)
+
+			return &RolloutError{Name: name, Err: fmt.Errorf("%v\n[!] '%s' is a Job, it was not rolled back", err, fullServiceName)}
+		}
+
+		undo := serviceConfig.Strategy == constants.RollingStrategy && liveImage != "" && workload.revisioned()
+
+		restoredImage, rollbackErr := rollbackWorkload(env, cwd, label, workload, undo, deploymentFilePath, dockerImagePath, previousDockerImagePath)
+
+		if rollbackErr != nil {
+			record(deployFailed)
+
+			return &RolloutError{Name: name, Err: fmt.Errorf("%v\n%v", err, rollbackErr)}
+		}
+
+		if rollbackErr := waitForRollout(env, cwd, workload, restoredImage, serviceConfig.RolloutTimeout); rollbackErr != nil {
+			record(deployFailed)
+
+			return &RolloutError{Name: name, Err: fmt.Errorf("%v\n[!] Rollback did not become healthy either: %v", err, rollbackErr)}
+		}
+
+		record(deployRolledBack)
+
+		return &RolloutError{
+			Name:       name,
+			Err:        fmt.Errorf("%v\n[!] Rolled '%s' back to %s", err, fullServiceName, restoredImage),
+			RolledBack: true,
+		}
+	}
+
+	if err := applyExtraManifests(env, cwd, label, request.ServiceName, afterWorkload); err != nil {
+		record(deployFailed)
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("[!] Failed to apply service YAML file for '%s': %v", fullServiceName, err)
+		return err
 	}
 
-	fmt.Println(output.String())
+	record(deploySucceeded)
 
 	fmt.Println("[+] Deployment process completed...")
 
@@ -73,61 +211,109 @@ func deploy(
 
 func DeployAlone(
 	cfg *types.K8sDeployerConfig,
+	args *types.Args,
 	cwd,
-	mode,
-	serviceType,
 	serviceName string,
 ) error {
-	serviceDirectoryRoot := GetServiceDirectoryRoot(cfg, cwd, serviceType, serviceName)
-	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, serviceDirectoryRoot, mode, serviceType, serviceName)
+	serviceType := args.MicroserviceType
 
-	fmt.Printf("[+] Parsing deployment YAML file: %s\n", deploymentYamlPath)
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return err
+	}
+
+	serviceDirectoryRoot, err := GetServiceDirectoryRoot(cfg, cwd, serviceType, serviceName)
+	if err != nil {
+		return err
+	}
 
-	deployment, err := ParseYaml(deploymentYamlPath)
+	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, env, serviceDirectoryRoot, serviceType, serviceName)
 
+	fmt.Printf("[+] Parsing deployment YAML file: %s\n", deploymentYamlPath)
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, serviceName)
 	if err != nil {
 		return err
-	} else if deployment == nil {
-		return fmt.Errorf("[!] Failed to parse deployment YAML file")
 	}
 
-	if len(deployment.Spec.Template.Spec.Containers) == 0 {
-		return fmt.Errorf("[!] Failed to find container in deployment YAML file")
+	dockerImagePath := workload.Image
+
+	fmt.Println("[+] Extracting current version of the Docker image...")
+	currentVersion, err := ParseVersion(dockerImagePath)
+
+	if err != nil {
+		return err
 	}
 
-	dockerImagePath := deployment.Spec.Template.Spec.Containers[0].Image
+	previousDockerImagePath := dockerImagePath
+	dockerImagePath = ParseDockerImagePath(cfg, env, serviceName, currentVersion)
 
-	fmt.Println("[+] Extracting current version of the Docker image and generating the next verison...")
-	currentVersion, _ := ParseVersion(dockerImagePath)
+	emitResolvedEvent(env, "deploy", serviceType, serviceName, dockerImagePath, previousDockerImagePath, currentVersion, currentVersion, deploymentYamlPath, serviceYamlPath)
 
-	dockerImagePath = ParseDockerImagePath(cfg, mode, serviceName, currentVersion)
+	if args.DryRun {
+		fmt.Println("[dry-run] Deploy plan:")
+		fmt.Printf("[dry-run]     environment:       %s\n", env.Name)
+		fmt.Printf("[dry-run]     service directory: %s\n", serviceDirectoryRoot)
+		fmt.Printf("[dry-run]     deployment YAML:   %s\n", deploymentYamlPath)
+		fmt.Printf("[dry-run]     service YAML:      %s\n", serviceYamlPath)
+		fmt.Printf("[dry-run]     image:             %s\n", dockerImagePath)
+	}
 
-	return deploy(
-		cfg,
-		serviceDirectoryRoot,
-		mode,
-		serviceName,
-		dockerImagePath,
-		deploymentYamlPath,
-		serviceYamlPath,
-	)
+	return deploy(cfg, env, deployRequest{
+		ProjectRoot:          cwd,
+		ServiceDirectoryRoot: serviceDirectoryRoot,
+		ServiceName:          serviceName,
+		ServiceType:          serviceType,
+		Image:                dockerImagePath,
+		DeploymentYamlPath:   deploymentYamlPath,
+		ServiceYamlPath:      serviceYamlPath,
+		Diff:                 args.Diff,
+		Confirm:              args.Confirm,
+		DryRun:               args.DryRun,
+	})
 }
 
 func DeployAfterBuild(
 	cfg *types.K8sDeployerConfig,
+	args *types.Args,
 	buildInfo *BuildInfo,
-	mode,
 	serviceName string,
 ) error {
-	return deploy(
-		cfg,
-		buildInfo.ServiceDirectoryRoot,
-		mode,
-		serviceName,
-		buildInfo.NewDockerImagePath,
-		buildInfo.DeploymentYamlPath,
-		buildInfo.ServiceYamlPath,
-	)
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return err
+	}
+
+	return deploy(cfg, env, deployRequest{
+		ProjectRoot:          buildInfo.ProjectRoot,
+		ServiceDirectoryRoot: buildInfo.ServiceDirectoryRoot,
+		ServiceName:          serviceName,
+		ServiceType:          args.MicroserviceType,
+		Image:                buildInfo.NewDockerImagePath,
+		ExtraImages:          buildInfo.ExtraDockerImagePaths,
+		DeploymentYamlPath:   buildInfo.DeploymentYamlPath,
+		ServiceYamlPath:      buildInfo.ServiceYamlPath,
+		PreviousImage:        buildInfo.PreviousDockerImagePath,
+		Diff:                 args.Diff,
+		Confirm:              args.Confirm,
+		DryRun:               args.DryRun,
+	})
+}
+
+// IsDeployed reports whether the service's workload in the --mode environment already
+// runs the image.
+func IsDeployed(cfg *types.K8sDeployerConfig, args *types.Args, serviceName, deploymentYamlPath, dockerImagePath string) (bool, error) {
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return false, err
+	}
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, serviceName)
+	if err != nil {
+		return false, err
+	}
+
+	return getLiveImage(env, "", workload) == dockerImagePath, nil
 }
 
 // func removeMinikubeImage(dockerImagePath string) error {
@@ -141,55 +327,81 @@ func DeployAfterBuild(
 // 	return nil
 // }
 
-func loadDockerImageToMinikube(cwd, dockerImagePath string) (string, error) {
+func loadDockerImageToMinikube(cwd, label, dockerImagePath string) (string, error) {
 	fmt.Printf("[->] Loading docker image (%s) to minikube...\n", dockerImagePath)
 
-	var output bytes.Buffer
-	cmd := exec.Command("minikube", "image", "load", dockerImagePath)
+	output, errOutput, err := runCommand(Command{
+		Name:  "minikube",
+		Args:  []string{"image", "load", dockerImagePath},
+		Dir:   cwd,
+		Label: label,
+	})
 
-	cmd.Dir = cwd
-	cmd.Stdout = &output
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("[!] Failed to load Docker image into Minikube: %v", err)
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to load Docker image into Minikube: %s", commandError(err, errOutput))
 	}
 
-	return output.String(), nil
+	return output, nil
 }
 
-func pushDockerImageToLive(cwd, dockerImagePath string) (string, error) {
-	fmt.Printf("[->] Loading docker image (%s) to minikube...\n", dockerImagePath)
-
-	var output bytes.Buffer
-	cmd := exec.Command("docker", "push", dockerImagePath)
+func loadDockerImageToKind(cwd, label, clusterName, dockerImagePath string) (string, error) {
+	fmt.Printf("[->] Loading docker image (%s) to kind cluster '%s'...\n", dockerImagePath, clusterName)
 
-	cmd.Dir = cwd
-	cmd.Stdout = &output
+	output, errOutput, err := runCommand(Command{
+		Name:  "kind",
+		Args:  []string{"load", "docker-image", dockerImagePath, "--name", clusterName},
+		Dir:   cwd,
+		Label: label,
+	})
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("[!] Failed to push the image into the Docker registry: %v", err)
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to load Docker image into kind: %s", commandError(err, errOutput))
 	}
 
-	return output.String(), nil
+	return output, nil
 }
 
-func deleteExistingDeployment(fullServiceName string) {
-	fmt.Println("[+] Deleting existing deployment...")
+func pushDockerImageToLive(cwd, label, dockerImagePath string) (string, error) {
+	fmt.Printf("[->] Pushing docker image (%s) to the registry...\n", dockerImagePath)
+
+	output, errOutput, err := runCommand(Command{
+		Name:  "docker",
+		Args:  []string{"push", dockerImagePath},
+		Dir:   cwd,
+		Label: label,
+	})
 
-	cmd := exec.Command("kubectl", "delete", "deployment", fullServiceName+"-deployment")
-	cmd.Env = os.Environ()
+	if err != nil {
+		return "", fmt.Errorf("[!] Failed to push the image into the Docker registry: %s", commandError(err, errOutput))
+	}
+
+	return output, nil
+}
 
-	var errOutput bytes.Buffer
-	cmd.Stderr = &errOutput
+func pushDockerImagesToLive(cwd, label string, dockerImagePaths []string) (string, error) {
+	var outputs []string
 
-	if err := cmd.Run(); err != nil {
-		if errOutput.Len() > 0 {
-			fmt.Println(errOutput.String())
+	for _, dockerImagePath := range dockerImagePaths {
+		output, err := pushDockerImageToLive(cwd, label, dockerImagePath)
+		if err != nil {
+			return strings.Join(outputs, "\n"), err
 		}
 
-		fmt.Printf("[!] Failed to delete existing deployment or maybe there wasn't any deployment yet: %v\n", err)
-		return
+		outputs = append(outputs, output)
 	}
 
-	fmt.Println("[+] Deleted existing deployment if any")
+	return strings.Join(outputs, "\n"), nil
+}
+
+// rollbackTarget describes the image a failed rollout is rolled back to.
+func rollbackTarget(previousDockerImagePath string) string {
+	if previousDockerImagePath == "" {
+		return "the image currently running in the cluster"
+	}
+
+	return previousDockerImagePath
+}
+
+func deploymentName(fullServiceName string) string {
+	return fullServiceName + "-deployment"
 }
@@ -0,0 +1,290 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const testDeploymentName = "udecrypt-image-service-deployment"
+
+func deploymentStateJson(generation, observed, updated, available int) string {
+	return fmt.Sprintf(
+		`{"metadata":{"generation":%d},"spec":{"replicas":1,"selector":{"matchLabels":{"app":"udecrypt-image-service"}}},`+
+			`"status":{"observedGeneration":%d,"replicas":1,"updatedReplicas":%d,"availableReplicas":%d}}`,
+		generation, observed, updated, available,
+	)
+}
+
+func crashingPodsJson(image string) string {
+	return fmt.Sprintf(
+		`{"items":[{"metadata":{"name":"image-7d9"},"spec":{"containers":[{"image":%q}]},`+
+			`"status":{"containerStatuses":[{"name":"udecrypt-image-service","state":{"waiting":{"reason":"CrashLoopBackOff","message":"back-off"}}}]}}]}`,
+		image,
+	)
+}
+
+func TestDeployAloneAppliesManifestsAndWaitsForRollout(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: deploymentStateJson(2, 2, 1, 1)})
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.0"})
+
+	if err := DeployAlone(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("DeployAlone returned an error: %v", err)
+	}
+
+	kubernetesDirectory := filepath.Join(cwd, "services", "go", "image", "k8s")
+	expected := []string{
+		"minikube image load udecrypt_image:1.0.1",
+		"kubectl get deployment " + testDeploymentName + " -o jsonpath={.spec.template.spec.containers[0].image} --ignore-not-found",
+		"kubectl apply -f " + filepath.Join(kubernetesDirectory, "service.yaml"),
+		"kubectl apply -f " + filepath.Join(kubernetesDirectory, "deployment.dev.yaml"),
+		"kubectl get deployment " + testDeploymentName + " -o json",
+		// the commit recorded in the deploy history
+		"git rev-parse --short HEAD",
+		"git status --porcelain -- .",
+	}
+
+	if lines := recorder.CommandLines(); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected commands:\n%s\nexpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// Streamed output of services deployed in parallel is told apart by the label
+	for _, cmd := range recorder.Commands() {
+		if !cmd.Quiet && cmd.Label != "go/image" {
+			t.Errorf("%q is labelled %q, expected go/image", cmd.String(), cmd.Label)
+		}
+	}
+}
+
+func TestDeployAfterBuildRecreateDeletesAfterDelivery(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	cfg.Services = map[string]types.ServiceConfig{"image": {Strategy: "recreate"}}
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	args := &types.Args{DeployTo: "prod", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if err := DeployAfterBuild(cfg, args, buildInfo, "image"); err != nil {
+		t.Fatalf("DeployAfterBuild returned an error: %v", err)
+	}
+
+	lines := recorder.CommandLines()
+	push, remove := -1, -1
+
+	for i, line := range lines {
+		switch line {
+		case "docker push registry.example.com/udecrypt/udecrypt_image:1.0.2":
+			push = i
+		case "kubectl delete deployment " + testDeploymentName:
+			remove = i
+		}
+	}
+
+	if push < 0 || remove < 0 || remove < push {
+		t.Errorf("expected the push before the delete, got:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestDeployDoesNotTouchClusterWhenPushFails(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("docker push", RecordedOutput{Stderr: "denied", Err: errors.New("exit status 1")})
+
+	err := DeployAlone(cfg, &types.Args{DeployTo: "prod", MicroserviceType: "go"}, cwd, "image")
+
+	var deployErr *DeployError
+	if !errors.As(err, &deployErr) {
+		t.Fatalf("expected a *DeployError, got %v", err)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl") {
+			t.Errorf("kubectl ran after a failed push: %s", line)
+		}
+	}
+}
+
+func TestDeployAfterBuildRollsBackUnhealthyRollout(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On(
+		"kubectl get deployment "+testDeploymentName+" -o json",
+		RecordedOutput{Stdout: deploymentStateJson(3, 3, 1, 0)},
+		RecordedOutput{Stdout: deploymentStateJson(4, 4, 1, 1)},
+	)
+	// "-o json" is a prefix of "-o jsonpath", so the live image lookup is registered last
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.1"})
+	recorder.On("kubectl get pods -l app=udecrypt-image-service", RecordedOutput{Stdout: crashingPodsJson("udecrypt_image:1.0.2")})
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	err = DeployAfterBuild(cfg, args, buildInfo, "image")
+
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) || !rolloutErr.RolledBack {
+		t.Fatalf("expected a rolled back *RolloutError, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "CrashLoopBackOff") {
+		t.Errorf("expected the error to name the pod failure, got %v", err)
+	}
+
+	undone := false
+	services := 0
+
+	for _, line := range recorder.CommandLines() {
+		if line == "kubectl rollout undo deployment/"+testDeploymentName {
+			undone = true
+		}
+
+		if strings.HasPrefix(line, "kubectl apply -f") && strings.HasSuffix(line, "service.yaml") {
+			services++
+		}
+	}
+
+	if services != 1 {
+		t.Errorf("expected the service YAML to be applied once, before the rollout, got %d times", services)
+	}
+
+	if !undone {
+		t.Errorf("expected a rollout undo, got:\n%s", strings.Join(recorder.CommandLines(), "\n"))
+	}
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != testDeploymentYaml {
+		t.Errorf("deployment YAML was not restored:\n%s", yaml)
+	}
+}
+
+func TestDeployRollbackWritesTheImageTheUndoRestored(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On(
+		"kubectl get deployment "+testDeploymentName+" -o json",
+		RecordedOutput{Stdout: deploymentStateJson(3, 3, 1, 0)},
+		RecordedOutput{Stdout: deploymentStateJson(4, 4, 1, 1)},
+	)
+	// Someone set 1.0.1 by hand on top of 1.0.0, so undoing 1.0.2 lands on 1.0.1's previous revision
+	recorder.On(
+		"kubectl get deployment "+testDeploymentName+" -o jsonpath",
+		RecordedOutput{Stdout: "udecrypt_image:1.0.1"},
+		RecordedOutput{Stdout: "udecrypt_image:1.0.0"},
+	)
+	recorder.On("kubectl get pods -l app=udecrypt-image-service", RecordedOutput{Stdout: crashingPodsJson("udecrypt_image:1.0.2")})
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	err = DeployAfterBuild(cfg, args, buildInfo, "image")
+
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) || !rolloutErr.RolledBack || !strings.Contains(err.Error(), "back to udecrypt_image:1.0.0") {
+		t.Fatalf("expected a *RolloutError rolled back to udecrypt_image:1.0.0, got %v", err)
+	}
+
+	expected := strings.Replace(testDeploymentYaml, "udecrypt_image:1.0.1", "udecrypt_image:1.0.0", 1)
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != expected {
+		t.Errorf("the deployment YAML does not match what the undo left running:\n%s", yaml)
+	}
+}
+
+func pinnedEnvironment(cfg *types.K8sDeployerConfig) {
+	cfg.Environments = map[string]types.EnvironmentConfig{
+		"staging": {
+			Files:         cfg.KubernetesConfig.Files.Dev,
+			Kubeconfig:    "/etc/kube/staging.yaml",
+			KubeContext:   "staging",
+			Namespace:     "udecrypt",
+			ClusterServer: "https://10.0.0.1:6443",
+		},
+	}
+}
+
+func TestDeployPinsKubectlToTheEnvironmentCluster(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	pinnedEnvironment(cfg)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml config get-contexts", RecordedOutput{Stdout: "prod\nstaging\n"})
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml config view", RecordedOutput{Stdout: "https://10.0.0.1:6443/"})
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml --context staging --namespace udecrypt get deployment", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	if err := DeployAlone(cfg, &types.Args{DeployTo: "staging", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("DeployAlone returned an error: %v", err)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl") && !strings.HasPrefix(line, "kubectl --kubeconfig /etc/kube/staging.yaml ") {
+			t.Errorf("kubectl ran without the environment's kubeconfig: %s", line)
+		}
+
+		if strings.Contains(line, " apply ") && !strings.Contains(line, "--context staging --namespace udecrypt") {
+			t.Errorf("kubectl apply ran without the environment's context and namespace: %s", line)
+		}
+	}
+}
+
+func TestDeployRefusesAnUnexpectedCluster(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	pinnedEnvironment(cfg)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml config get-contexts", RecordedOutput{Stdout: "staging\n"})
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml config view", RecordedOutput{Stdout: "https://prod.example.com"})
+
+	err := DeployAlone(cfg, &types.Args{DeployTo: "staging", MicroserviceType: "go"}, cwd, "image")
+
+	var clusterErr *ClusterError
+	if !errors.As(err, &clusterErr) {
+		t.Fatalf("expected a *ClusterError, got %v", err)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if !strings.Contains(line, " config ") {
+			t.Errorf("ran %q against an unexpected cluster", line)
+		}
+	}
+
+	// A dry run reads the kubeconfig too, so it warns about the same cluster
+	var planned strings.Builder
+	SetCommandRunner(&DryRunRunner{Out: &planned, Queries: recorder})
+
+	err = DeployAlone(cfg, &types.Args{DeployTo: "staging", MicroserviceType: "go", DryRun: true}, cwd, "image")
+
+	if !errors.As(err, &clusterErr) {
+		t.Fatalf("expected a *ClusterError from the dry run, got %v", err)
+	}
+}
+
+func TestDeployRefusesAMissingContext(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	pinnedEnvironment(cfg)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl --kubeconfig /etc/kube/staging.yaml config get-contexts", RecordedOutput{Stdout: "prod\n"})
+
+	err := DeployAlone(cfg, &types.Args{DeployTo: "staging", MicroserviceType: "go"}, cwd, "image")
+
+	var clusterErr *ClusterError
+	if !errors.As(err, &clusterErr) || !strings.Contains(err.Error(), "'staging' is not in the kubeconfig") {
+		t.Fatalf("expected a *ClusterError for the missing context, got %v", err)
+	}
+}
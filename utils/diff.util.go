@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+type diffOperation struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff renders the line differences between two texts in unified diff format, or
+// returns an empty string when they are equal.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	operations := diffLines(splitLines(from), splitLines(to))
+
+	var diff strings.Builder
+
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(operations); {
+		// Find the next change and the context window around it
+		change := start
+		for change < len(operations) && operations[change].kind == ' ' {
+			change++
+		}
+
+		if change == len(operations) {
+			break
+		}
+
+		hunkStart := max(change-diffContextLines, start)
+
+		// Changes closer together than twice the context share one hunk
+		lastChange := change
+
+		for k := change + 1; k < len(operations) && k-lastChange <= 2*diffContextLines; k++ {
+			if operations[k].kind != ' ' {
+				lastChange = k
+			}
+		}
+
+		hunkEnd := min(lastChange+1+diffContextLines, len(operations))
+
+		writeHunk(&diff, operations, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+
+	return diff.String()
+}
+
+func writeHunk(diff *strings.Builder, operations []diffOperation, start, end int) {
+	fromLine, toLine := 1, 1
+
+	for _, operation := range operations[:start] {
+		if operation.kind != '+' {
+			fromLine++
+		}
+
+		if operation.kind != '-' {
+			toLine++
+		}
+	}
+
+	fromCount, toCount := 0, 0
+
+	for _, operation := range operations[start:end] {
+		if operation.kind != '+' {
+			fromCount++
+		}
+
+		if operation.kind != '-' {
+			toCount++
+		}
+	}
+
+	if fromCount == 0 {
+		fromLine--
+	}
+
+	if toCount == 0 {
+		toLine--
+	}
+
+	fmt.Fprintf(diff, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+
+	for _, operation := range operations[start:end] {
+		diff.WriteByte(operation.kind)
+		diff.WriteString(operation.line)
+		diff.WriteByte('\n')
+	}
+}
+
+// diffLines computes an edit script from the longest common subsequence of both line lists.
+func diffLines(from, to []string) []diffOperation {
+	common := make([][]int, len(from)+1)
+
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	operations := make([]diffOperation, 0, len(from)+len(to))
+	i, j := 0, 0
+
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			operations = append(operations, diffOperation{' ', from[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			operations = append(operations, diffOperation{'-', from[i]})
+			i++
+		default:
+			operations = append(operations, diffOperation{'+', to[j]})
+			j++
+		}
+	}
+
+	for ; i < len(from); i++ {
+		operations = append(operations, diffOperation{'-', from[i]})
+	}
+
+	for ; j < len(to); j++ {
+		operations = append(operations, diffOperation{'+', to[j]})
+	}
+
+	return operations
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
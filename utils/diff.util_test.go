@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+
+	expected := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`
+
+	if diff := UnifiedDiff("old", "new", from, to); diff != expected {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	if diff := UnifiedDiff("old", "new", from, from); diff != "" {
+		t.Errorf("expected no diff for equal texts, got:\n%s", diff)
+	}
+}
@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// dockerBuild is everything `docker build` needs for one image.
+type dockerBuild struct {
+	ServiceDirectoryRoot string
+	Context              string // absolute build context
+	Dockerfile           string // absolute Dockerfile path, empty for the context's Dockerfile
+	Image                string
+	ExtraImages          []string // the same image under its extra tags
+	BuildArgs            map[string]string
+	Target               string
+	Secrets              []string
+	Platforms            []Platform
+	Push                 bool   // buildx pushes the image instead of loading it
+	Label                string // of the docker command, see Command.Label
+}
+
+// DockerTemplateData is what Docker build args and extra tags are templated with, e.g.
+// "{{.Version}}-{{.GitSha}}".
+type DockerTemplateData struct {
+	Version         string
+	ServiceName     string
+	FullServiceName string
+	Environment     string
+	Image           string
+
+	serviceDirectoryRoot string
+	gitShaOnce           sync.Once
+	gitSha               string
+}
+
+// GitSha is the short HEAD commit of the repository, suffixed with "-dirty" when the service
+// directory has uncommitted changes. It is only looked up when a template uses it.
+func (d *DockerTemplateData) GitSha() string {
+	d.gitShaOnce.Do(func() {
+		sha, err := gitShortSha(d.serviceDirectoryRoot)
+
+		if err != nil {
+			fmt.Printf("[!] Failed to read the git commit, using 'unknown': %v\n", err)
+			sha = "unknown"
+		}
+
+		d.gitSha = sha
+	})
+
+	return d.gitSha
+}
+
+// resolveDockerConfig applies the service's Docker overrides for the environment.
+func resolveDockerConfig(serviceConfig types.ServiceConfig, env *types.EnvironmentConfig) types.DockerConfig {
+	dockerConfig := serviceConfig.Docker
+	override, found := lookupEnvironmentKey(dockerConfig.Environments, env.Name)
+
+	dockerConfig.Environments = nil
+
+	if !found {
+		return dockerConfig
+	}
+
+	if override.Context != "" {
+		dockerConfig.Context = override.Context
+	}
+
+	if override.ContextRelativeTo != "" {
+		dockerConfig.ContextRelativeTo = override.ContextRelativeTo
+	}
+
+	if override.Dockerfile != "" {
+		dockerConfig.Dockerfile = override.Dockerfile
+	}
+
+	if override.Target != "" {
+		dockerConfig.Target = override.Target
+	}
+
+	buildArgs := map[string]string{}
+
+	for key, value := range dockerConfig.BuildArgs {
+		buildArgs[key] = value
+	}
+
+	for key, value := range override.BuildArgs {
+		buildArgs[key] = value
+	}
+
+	dockerConfig.BuildArgs = buildArgs
+	dockerConfig.Secrets = append(append([]string{}, dockerConfig.Secrets...), override.Secrets...)
+	dockerConfig.Tags = append(append([]string{}, dockerConfig.Tags...), override.Tags...)
+
+	return dockerConfig
+}
+
+// newDockerBuild resolves the Docker settings of a service into the build of one image.
+func newDockerBuild(
+	cfg *types.K8sDeployerConfig,
+	env *types.EnvironmentConfig,
+	serviceConfig types.ServiceConfig,
+	cwd, serviceDirectoryRoot, outputDirectory, serviceName, version, image string,
+	platforms []Platform,
+) (*dockerBuild, error) {
+	dockerConfig := resolveDockerConfig(serviceConfig, env)
+
+	context, err := dockerContext(dockerConfig, cwd, serviceDirectoryRoot, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &dockerBuild{
+		ServiceDirectoryRoot: serviceDirectoryRoot,
+		Context:              context,
+		Image:                image,
+		BuildArgs:            map[string]string{},
+		Target:               dockerConfig.Target,
+		Secrets:              dockerConfig.Secrets,
+		Platforms:            platforms,
+		Push:                 pushedByBuildx(env, serviceConfig),
+	}
+
+	if dockerConfig.Dockerfile != "" {
+		build.Dockerfile = filepath.Join(serviceDirectoryRoot, dockerConfig.Dockerfile)
+	} else if build.Context != serviceDirectoryRoot {
+		build.Dockerfile = filepath.Join(serviceDirectoryRoot, "Dockerfile")
+	}
+
+	data := &DockerTemplateData{
+		Version:              version,
+		ServiceName:          serviceName,
+		FullServiceName:      ParseServiceName(cfg.DockerImagePrefix, serviceName),
+		Environment:          env.Name,
+		Image:                image,
+		serviceDirectoryRoot: serviceDirectoryRoot,
+	}
+
+	for key, value := range dockerConfig.BuildArgs {
+		rendered, err := renderDockerTemplate(serviceName, "build arg "+key, value, data)
+		if err != nil {
+			return nil, err
+		}
+
+		build.BuildArgs[key] = rendered
+	}
+
+	// The Dockerfile finds the build output through a build argument, relative to the build
+	// context, since an isolated output directory has a new name on every run. It can only
+	// COPY from there when the output directory is inside the context.
+	relativeOutputDirectory, err := filepath.Rel(build.Context, outputDirectory)
+
+	if err != nil || relativeOutputDirectory == ".." || strings.HasPrefix(relativeOutputDirectory, ".."+string(filepath.Separator)) {
+		return nil, &ConfigError{Reason: fmt.Sprintf(
+			"The build output directory %s of %s is outside its Docker context %s, set Docker.Context to a directory containing it",
+			outputDirectory, serviceName, build.Context,
+		)}
+	}
+
+	build.BuildArgs["BUILD_OUTPUT_DIR"] = filepath.ToSlash(relativeOutputDirectory)
+
+	for _, tag := range dockerConfig.Tags {
+		rendered, err := renderDockerTemplate(serviceName, "tag", tag, data)
+		if err != nil {
+			return nil, err
+		}
+
+		extraImage, err := extraImageReference(image, rendered)
+		if err != nil {
+			return nil, err
+		}
+
+		build.ExtraImages = append(build.ExtraImages, extraImage)
+	}
+
+	return build, nil
+}
+
+// dockerContext resolves the absolute Docker build context of a service.
+func dockerContext(dockerConfig types.DockerConfig, cwd, serviceDirectoryRoot, serviceName string) (string, error) {
+	switch dockerConfig.ContextRelativeTo {
+	case "", constants.ServiceRelative:
+		return filepath.Join(serviceDirectoryRoot, dockerConfig.Context), nil
+	case constants.RootRelative:
+		return filepath.Join(cwd, dockerConfig.Context), nil
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf(
+			"Unknown Docker ContextRelativeTo '%s' for %s (expected %s or %s)",
+			dockerConfig.ContextRelativeTo,
+			serviceName,
+			constants.ServiceRelative,
+			constants.RootRelative,
+		)}
+	}
+}
+
+func renderDockerTemplate(serviceName, name, text string, data *DockerTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", &ConfigError{Reason: fmt.Sprintf("Invalid Docker %s template for %s: %v", name, serviceName, err)}
+	}
+
+	var rendered strings.Builder
+
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", &ConfigError{Reason: fmt.Sprintf("Invalid Docker %s template for %s: %v", name, serviceName, err)}
+	}
+
+	return rendered.String(), nil
+}
+
+// extraImageReference turns an extra tag into a full image reference. A plain tag such as
+// "latest" tags the image's own repository; anything with a ':' or '/' is used as is.
+func extraImageReference(image, tag string) (string, error) {
+	if strings.ContainsAny(tag, ":/") {
+		if _, err := ParseImageReference(tag); err != nil {
+			return "", err
+		}
+
+		return tag, nil
+	}
+
+	reference, err := ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	reference.Tag, reference.Digest = tag, ""
+
+	if !tagPattern.MatchString(tag) {
+		return "", &ImageReferenceError{Image: reference.String(), Reason: fmt.Sprintf("invalid tag '%s'", tag)}
+	}
+
+	return reference.String(), nil
+}
+
+// buildDockerImage builds the image with `docker build`, or with `docker buildx build` for
+// services with Platforms: pushed as a multi-platform image when Push is set, otherwise
+// loaded into the local Docker as a single-platform one.
+func buildDockerImage(build *dockerBuild) (string, error) {
+	args := []string{"build"}
+
+	if len(build.Platforms) > 0 {
+		args = []string{"buildx", "build", "--platform", platformList(build.Platforms)}
+	}
+
+	for _, image := range append([]string{build.Image}, build.ExtraImages...) {
+		args = append(args, "-t", image)
+	}
+
+	if build.Dockerfile != "" {
+		args = append(args, "-f", build.Dockerfile)
+	}
+
+	if build.Target != "" {
+		args = append(args, "--target", build.Target)
+	}
+
+	for _, key := range sortedKeys(build.BuildArgs) {
+		args = append(args, "--build-arg", key+"="+build.BuildArgs[key])
+	}
+
+	for _, secret := range build.Secrets {
+		args = append(args, "--secret", secret)
+	}
+
+	if len(build.Platforms) > 0 && build.Push {
+		args = append(args, "--push")
+	} else if len(build.Platforms) > 0 {
+		args = append(args, "--load")
+	}
+
+	context := "."
+
+	if build.Context != build.ServiceDirectoryRoot {
+		context = build.Context
+	}
+
+	cmd := Command{Name: "docker", Args: append(args, context), Dir: build.ServiceDirectoryRoot, Label: build.Label}
+
+	// Secret mounts need BuildKit, which plain `docker build` only uses by default on
+	// recent Docker versions
+	if len(build.Secrets) > 0 && len(build.Platforms) == 0 {
+		cmd.Env = []string{"DOCKER_BUILDKIT=1"}
+	}
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return errOutput, fmt.Errorf("[!] Failed to build Docker image: %v", err)
+	}
+
+	return output, nil
+}
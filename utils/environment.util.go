@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// GetEnvironments returns the configured environments keyed by name or glob pattern,
+// falling back to the legacy dev/prod settings when none are configured.
+func GetEnvironments(cfg *types.K8sDeployerConfig) map[string]types.EnvironmentConfig {
+	if len(cfg.Environments) > 0 {
+		return cfg.Environments
+	}
+
+	return map[string]types.EnvironmentConfig{
+		constants.Dev: {
+			Registry:         cfg.DockerContainerRegistry.Dev,
+			Files:            cfg.KubernetesConfig.Files.Dev,
+			Kubeconfig:       cfg.KubernetesConfig.Clusters.Dev.Kubeconfig,
+			KubeContext:      cfg.KubernetesConfig.Clusters.Dev.KubeContext,
+			Namespace:        cfg.KubernetesConfig.Clusters.Dev.Namespace,
+			ClusterServer:    cfg.KubernetesConfig.Clusters.Dev.ClusterServer,
+			HistoryConfigMap: cfg.KubernetesConfig.Clusters.Dev.HistoryConfigMap,
+			ImageDelivery:    constants.MinikubeDelivery,
+		},
+		constants.Prod: {
+			Registry:         cfg.DockerContainerRegistry.Prod,
+			Files:            cfg.KubernetesConfig.Files.Prod,
+			Kubeconfig:       cfg.KubernetesConfig.Clusters.Prod.Kubeconfig,
+			KubeContext:      cfg.KubernetesConfig.Clusters.Prod.KubeContext,
+			Namespace:        cfg.KubernetesConfig.Clusters.Prod.Namespace,
+			ClusterServer:    cfg.KubernetesConfig.Clusters.Prod.ClusterServer,
+			HistoryConfigMap: cfg.KubernetesConfig.Clusters.Prod.HistoryConfigMap,
+			ImageDelivery:    constants.RegistryDelivery,
+		},
+	}
+}
+
+// GetEnvironment resolves the --mode value against the configured environments. An exact
+// name wins over glob patterns, and patterns are tried in lexical order.
+func GetEnvironment(cfg *types.K8sDeployerConfig, mode string) (*types.EnvironmentConfig, error) {
+	environments := GetEnvironments(cfg)
+
+	environment, found := lookupEnvironmentKey(environments, mode)
+
+	if !found {
+		names := make([]string, 0, len(environments))
+
+		for name := range environments {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		return nil, &UsageError{Reason: fmt.Sprintf("Unknown mode '%s', configured environments: %s", mode, strings.Join(names, ", "))}
+	}
+
+	environment.Name = mode
+
+	if environment.Files.Deployment == "" || environment.Files.Service == "" {
+		return nil, &ConfigError{Reason: fmt.Sprintf("Environment '%s' is missing its Deployment or Service file name", mode)}
+	}
+
+	switch environment.ImageDelivery {
+	case "":
+		environment.ImageDelivery = constants.RegistryDelivery
+
+		if environment.Registry == "" {
+			environment.ImageDelivery = constants.MinikubeDelivery
+		}
+	case constants.MinikubeDelivery, constants.KindDelivery, constants.RegistryDelivery:
+	default:
+		return nil, &ConfigError{Reason: fmt.Sprintf(
+			"Unknown image delivery '%s' for environment '%s' (expected %s, %s or %s)",
+			environment.ImageDelivery,
+			mode,
+			constants.MinikubeDelivery,
+			constants.KindDelivery,
+			constants.RegistryDelivery,
+		)}
+	}
+
+	if environment.KindCluster == "" {
+		environment.KindCluster = "kind"
+	}
+
+	// kubectl runs inside the service directories, so the kubeconfig has to be absolute
+	if environment.Kubeconfig != "" {
+		kubeconfig, err := expandPath(environment.Kubeconfig)
+		if err != nil {
+			return nil, &ConfigError{Reason: fmt.Sprintf("Invalid Kubeconfig for environment '%s': %v", mode, err)}
+		}
+
+		environment.Kubeconfig = kubeconfig
+	}
+
+	return &environment, nil
+}
+
+// lookupEnvironmentKey finds the entry of a map keyed by environment name or glob pattern.
+// An exact name wins over patterns, and patterns are tried in lexical order.
+func lookupEnvironmentKey[T any](values map[string]T, name string) (T, bool) {
+	if value, found := values[name]; found {
+		return value, true
+	}
+
+	patterns := make([]string, 0, len(values))
+
+	for key := range values {
+		if strings.ContainsAny(key, "*?[") {
+			patterns = append(patterns, key)
+		}
+	}
+
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return values[pattern], true
+		}
+	}
+
+	var zero T
+
+	return zero, false
+}
+
+// kubectlCommand builds a kubectl command pinned to the environment's kubeconfig, context
+// and namespace.
+func kubectlCommand(env *types.EnvironmentConfig, args ...string) Command {
+	var kubectlArgs []string
+
+	if env.Kubeconfig != "" {
+		kubectlArgs = append(kubectlArgs, "--kubeconfig", env.Kubeconfig)
+	}
+
+	if env.KubeContext != "" {
+		kubectlArgs = append(kubectlArgs, "--context", env.KubeContext)
+	}
+
+	if env.Namespace != "" {
+		kubectlArgs = append(kubectlArgs, "--namespace", env.Namespace)
+	}
+
+	return Command{Name: "kubectl", Args: append(kubectlArgs, args...)}
+}
+
+// expandPath resolves a leading "~/" to the home directory and makes the path absolute.
+func expandPath(filePath string) (string, error) {
+	if strings.HasPrefix(filePath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		filePath = filepath.Join(home, filePath[2:])
+	}
+
+	return filepath.Abs(filePath)
+}
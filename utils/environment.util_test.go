@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestLookupEnvironmentKey(t *testing.T) {
+	values := map[string]string{
+		"preview":      "exact",
+		"preview-*":    "preview glob",
+		"preview-[ab]": "bracket glob",
+		"pr-?":         "single character glob",
+		"staging":      "staging",
+	}
+
+	cases := []struct {
+		name     string
+		key      string
+		expected string
+		found    bool
+	}{
+		{"exact name", "staging", "staging", true},
+		{"exact name beats the patterns", "preview", "exact", true},
+		{"star glob", "preview-42", "preview glob", true},
+		{"lexically first pattern wins", "preview-a", "preview glob", true},
+		{"single character glob", "pr-7", "single character glob", true},
+		{"glob does not cross the length", "pr-17", "", false},
+		{"unknown name", "prod", "", false},
+	}
+
+	for _, c := range cases {
+		value, found := lookupEnvironmentKey(values, c.key)
+
+		if found != c.found || value != c.expected {
+			t.Errorf("%s: lookupEnvironmentKey(%q) = %q, %v, expected %q, %v", c.name, c.key, value, found, c.expected, c.found)
+		}
+	}
+}
+
+func TestGetEnvironment(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	files := types.EnvironmentFiles{Deployment: "deployment.yaml", Service: "service.yaml"}
+
+	configured := &types.K8sDeployerConfig{
+		Environments: map[string]types.EnvironmentConfig{
+			"local":     {Files: files},
+			"staging":   {Files: files, Registry: "registry.example.com/staging", Kubeconfig: "~/.kube/staging"},
+			"preview-*": {Files: files, Registry: "registry.example.com/preview", ImageDelivery: constants.KindDelivery},
+			"kind-*":    {Files: files, ImageDelivery: constants.KindDelivery, KindCluster: "previews"},
+		},
+	}
+
+	legacy := &types.K8sDeployerConfig{
+		DockerContainerRegistry: types.DockerRegistry{Prod: "registry.example.com/udecrypt"},
+		KubernetesConfig: types.KubernetesConfig{
+			Files: types.FileConfig{
+				Dev:  types.EnvironmentFiles{Deployment: "deployment.dev.yaml", Service: "service.yaml"},
+				Prod: types.EnvironmentFiles{Deployment: "deployment.prod.yaml", Service: "service.yaml"},
+			},
+			Clusters: types.ClustersConfig{
+				Prod: types.ClusterConfig{KubeContext: "prod", Namespace: "udecrypt"},
+			},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		cfg      *types.K8sDeployerConfig
+		mode     string
+		expected types.EnvironmentConfig
+	}{
+		{
+			"empty registry delivers through minikube", configured, "local",
+			types.EnvironmentConfig{Name: "local", Files: files, ImageDelivery: constants.MinikubeDelivery, KindCluster: "kind"},
+		},
+		{
+			"a registry defaults to registry delivery and the kubeconfig expands", configured, "staging",
+			types.EnvironmentConfig{
+				Name:          "staging",
+				Files:         files,
+				Registry:      "registry.example.com/staging",
+				Kubeconfig:    filepath.Join(home, ".kube", "staging"),
+				ImageDelivery: constants.RegistryDelivery,
+				KindCluster:   "kind",
+			},
+		},
+		{
+			"glob key keeps the requested name", configured, "preview-42",
+			types.EnvironmentConfig{
+				Name:          "preview-42",
+				Files:         files,
+				Registry:      "registry.example.com/preview",
+				ImageDelivery: constants.KindDelivery,
+				KindCluster:   "kind",
+			},
+		},
+		{
+			"configured kind cluster is kept", configured, "kind-7",
+			types.EnvironmentConfig{Name: "kind-7", Files: files, ImageDelivery: constants.KindDelivery, KindCluster: "previews"},
+		},
+		{
+			"legacy dev delivers through minikube", legacy, constants.Dev,
+			types.EnvironmentConfig{
+				Name:          constants.Dev,
+				Files:         legacy.KubernetesConfig.Files.Dev,
+				ImageDelivery: constants.MinikubeDelivery,
+				KindCluster:   "kind",
+			},
+		},
+		{
+			"legacy prod pushes to the registry", legacy, constants.Prod,
+			types.EnvironmentConfig{
+				Name:          constants.Prod,
+				Files:         legacy.KubernetesConfig.Files.Prod,
+				Registry:      "registry.example.com/udecrypt",
+				KubeContext:   "prod",
+				Namespace:     "udecrypt",
+				ImageDelivery: constants.RegistryDelivery,
+				KindCluster:   "kind",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		env, err := GetEnvironment(c.cfg, c.mode)
+		if err != nil {
+			t.Errorf("%s: GetEnvironment(%q) failed: %v", c.name, c.mode, err)
+			continue
+		}
+
+		if *env != c.expected {
+			t.Errorf("%s: GetEnvironment(%q) = %+v, expected %+v", c.name, c.mode, *env, c.expected)
+		}
+	}
+}
+
+func TestGetEnvironmentRejectsBadEnvironments(t *testing.T) {
+	files := types.EnvironmentFiles{Deployment: "deployment.yaml", Service: "service.yaml"}
+
+	cfg := &types.K8sDeployerConfig{
+		Environments: map[string]types.EnvironmentConfig{
+			"staging":    {Files: files},
+			"no-service": {Files: types.EnvironmentFiles{Deployment: "deployment.yaml"}},
+			"ftp":        {Files: files, ImageDelivery: "ftp"},
+		},
+	}
+
+	var usageErr *UsageError
+
+	if _, err := GetEnvironment(cfg, "prod"); !errors.As(err, &usageErr) {
+		t.Errorf("an unknown mode returned %v, expected a UsageError", err)
+	}
+
+	for _, mode := range []string{"no-service", "ftp"} {
+		var configErr *ConfigError
+
+		if _, err := GetEnvironment(cfg, mode); !errors.As(err, &configErr) {
+			t.Errorf("mode %q returned %v, expected a ConfigError", mode, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+)
+
+// Failure classes of the errors returned by utils, see ErrorCategory
+const (
+	CategoryUsage              = "usage"
+	CategoryConfig             = "config"
+	CategoryCluster            = "cluster"
+	CategoryServiceNotFound    = "service-not-found"
+	CategoryUnknownServiceType = "unknown-service-type"
+	CategoryServiceDirectory   = "service-directory"
+	CategoryManifest           = "manifest"
+	CategoryVersion            = "version"
+	CategoryBuild              = "build"
+	CategoryDeploy             = "deploy"
+	CategoryRollout            = "rollout"
+	CategoryFailure            = "failure" // fits no other class
+)
+
+// ErrorCategory returns the failure class of an error returned by utils, or "" for nil.
+func ErrorCategory(err error) string {
+	var usageErr *UsageError
+	var configErr *ConfigError
+	var serviceNotFoundErr *ServiceNotFoundError
+	var unknownServiceTypeErr *UnknownServiceTypeError
+	var serviceDirectoryErr *ServiceDirectoryError
+	var manifestMissingErr *ManifestMissingError
+	var manifestErr *ManifestError
+	var imageReferenceErr *ImageReferenceError
+	var versionErr *VersionError
+	var rolloutErr *RolloutError
+	var buildErr *BuildError
+	var deployErr *DeployError
+	var clusterErr *ClusterError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &usageErr):
+		return CategoryUsage
+	case errors.As(err, &clusterErr):
+		return CategoryCluster
+	case errors.As(err, &configErr):
+		return CategoryConfig
+	case errors.As(err, &serviceNotFoundErr):
+		return CategoryServiceNotFound
+	case errors.As(err, &unknownServiceTypeErr):
+		return CategoryUnknownServiceType
+	case errors.As(err, &serviceDirectoryErr):
+		return CategoryServiceDirectory
+	case errors.As(err, &manifestMissingErr), errors.As(err, &manifestErr):
+		return CategoryManifest
+	case errors.As(err, &imageReferenceErr), errors.As(err, &versionErr):
+		return CategoryVersion
+	case errors.As(err, &rolloutErr):
+		return CategoryRollout
+	case errors.As(err, &buildErr):
+		return CategoryBuild
+	case errors.As(err, &deployErr):
+		return CategoryDeploy
+	default:
+		return CategoryFailure
+	}
+}
+
+// ExitCode maps an error returned by utils to the process exit code of its failure class.
+func ExitCode(err error) int {
+	switch ErrorCategory(err) {
+	case "":
+		return constants.ExitOK
+	case CategoryUsage:
+		return constants.ExitUsage
+	case CategoryCluster:
+		return constants.ExitCluster
+	case CategoryConfig:
+		return constants.ExitConfig
+	case CategoryServiceNotFound:
+		return constants.ExitServiceNotFound
+	case CategoryUnknownServiceType:
+		return constants.ExitUnknownServiceType
+	case CategoryServiceDirectory:
+		return constants.ExitServiceDirectory
+	case CategoryManifest:
+		return constants.ExitManifest
+	case CategoryVersion:
+		return constants.ExitVersion
+	case CategoryRollout:
+		return constants.ExitRollout
+	case CategoryBuild:
+		return constants.ExitBuild
+	case CategoryDeploy:
+		return constants.ExitDeploy
+	default:
+		return constants.ExitFailure
+	}
+}
+
+// ConfigError is returned when the k8s-deployer config is missing, unreadable or invalid.
+type ConfigError struct {
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "[!] " + e.Reason
+}
+
+// UsageError is returned when the command line asks for something that can't be done,
+// e.g. an unknown operation or mode.
+type UsageError struct {
+	Reason string
+}
+
+func (e *UsageError) Error() string {
+	return "[!] " + e.Reason
+}
+
+// ServiceNotFoundError is returned when a service is not configured in ServicesDirectory.All.
+type ServiceNotFoundError struct {
+	ServiceName string
+	ServiceType string // empty when the service was looked up across all types
+}
+
+func (e *ServiceNotFoundError) Error() string {
+	if e.ServiceType == "" {
+		return fmt.Sprintf("[!] Service %s not found in the configured paths.", e.ServiceName)
+	}
+
+	return fmt.Sprintf("[!] Service %s not found in the configured paths for type %s.", e.ServiceName, e.ServiceType)
+}
+
+// UnknownServiceTypeError is returned for a service type without a registered builder.
+type UnknownServiceTypeError struct {
+	ServiceType string
+	Registered  []string
+}
+
+func (e *UnknownServiceTypeError) Error() string {
+	return fmt.Sprintf("[!] Unknown service type: %s (registered types: %s)", e.ServiceType, strings.Join(e.Registered, ", "))
+}
+
+// ServiceDirectoryError is returned when a configured service directory is unusable.
+type ServiceDirectoryError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ServiceDirectoryError) Error() string {
+	return fmt.Sprintf("[!] Service directory %s: %s", e.Reason, e.Path)
+}
+
+// ManifestMissingError is returned when a Kubernetes manifest file does not exist.
+type ManifestMissingError struct {
+	Path string
+}
+
+func (e *ManifestMissingError) Error() string {
+	return fmt.Sprintf("[!] Kubernetes manifest not found: %s", e.Path)
+}
+
+// ManifestError is returned when a Kubernetes manifest can't be read, parsed or updated.
+type ManifestError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("[!] %s: %s", e.Reason, e.Path)
+}
+
+// BuildError is returned when compiling a service or building its Docker image fails.
+type BuildError struct {
+	ServiceName string
+	Err         error
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// DeployError is returned when making the image available to the cluster or applying
+// the manifests fails.
+type DeployError struct {
+	ServiceName string
+	Err         error
+}
+
+func (e *DeployError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeployError) Unwrap() error {
+	return e.Err
+}
+
+// RolloutError is returned when the applied workload never became healthy. RolledBack
+// tells whether the previous image was restored afterwards.
+type RolloutError struct {
+	Name       string
+	Err        error
+	RolledBack bool
+}
+
+func (e *RolloutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RolloutError) Unwrap() error {
+	return e.Err
+}
+
+// ClusterError is returned when the environment's kube context is missing or points at a
+// different cluster than the one the environment expects.
+type ClusterError struct {
+	Environment string
+	Reason      string
+}
+
+func (e *ClusterError) Error() string {
+	return fmt.Sprintf("[!] Refusing to deploy to '%s': %s", e.Environment, e.Reason)
+}
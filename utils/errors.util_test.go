@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+)
+
+func TestExitCodeOfEachFailureClass(t *testing.T) {
+	clusterErr := &ClusterError{Environment: "prod", Reason: "kube context 'prod' is not in the kubeconfig"}
+
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, constants.ExitOK},
+		{"usage", &UsageError{Reason: "no operation"}, constants.ExitUsage},
+		{"config", &ConfigError{Reason: "bad JSON"}, constants.ExitConfig},
+		{"cluster", clusterErr, constants.ExitCluster},
+		{"service not found", &ServiceNotFoundError{ServiceName: "billing"}, constants.ExitServiceNotFound},
+		{"unknown service type", &UnknownServiceTypeError{ServiceType: "cobol"}, constants.ExitUnknownServiceType},
+		{"service directory", &ServiceDirectoryError{Path: "services/go/api"}, constants.ExitServiceDirectory},
+		{"manifest missing", &ManifestMissingError{Path: "k8s/service.yaml"}, constants.ExitManifest},
+		{"manifest", &ManifestError{Path: "k8s/deployment.yaml"}, constants.ExitManifest},
+		{"image reference", &ImageReferenceError{Image: ":1.0.0"}, constants.ExitVersion},
+		{"version", &VersionError{Version: "latest"}, constants.ExitVersion},
+		{"build", &BuildError{ServiceName: "api", Err: errors.New("exit status 2")}, constants.ExitBuild},
+		{"deploy", &DeployError{ServiceName: "api", Err: errors.New("exit status 1")}, constants.ExitDeploy},
+		{"rollout", &RolloutError{Name: "api", Err: errors.New("CrashLoopBackOff")}, constants.ExitRollout},
+		{"unclassified", errors.New("disk full"), constants.ExitFailure},
+
+		// The most specific class wins over the error wrapping it
+		{"wrapped with fmt", fmt.Errorf("deploying api: %w", &VersionError{Version: "latest"}), constants.ExitVersion},
+		{"manifest inside a deploy", &DeployError{ServiceName: "api", Err: &ManifestError{Path: "k8s/hpa.yaml"}}, constants.ExitManifest},
+		{"config inside a build", &BuildError{ServiceName: "api", Err: &ConfigError{Reason: "unknown tool"}}, constants.ExitConfig},
+		{"cluster inside a deploy", &DeployError{ServiceName: "api", Err: clusterErr}, constants.ExitCluster},
+		{"cluster before config", errors.Join(&ConfigError{Reason: "bad kubeconfig"}, clusterErr), constants.ExitCluster},
+		{"usage before cluster", errors.Join(clusterErr, &UsageError{Reason: "--to needs one service"}), constants.ExitUsage},
+	}
+
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.expected {
+			t.Errorf("%s: ExitCode(%v) = %d, expected %d", c.name, c.err, code, c.expected)
+		}
+	}
+}
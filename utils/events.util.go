@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Event types written by --output json
+const (
+	EventStepStarted  = "step_started"
+	EventStepFinished = "step_finished"
+	EventResolved     = "resolved" // the image and manifests a step is going to use
+	EventStatus       = "status"   // the committed and live state of a service, see ServiceStatus
+	EventResult       = "result"   // always the last event of a run
+)
+
+// Event is one line of the newline-delimited JSON written by --output json. Fields that do
+// not apply to an event are left out.
+type Event struct {
+	Time            time.Time     `json:"Time"`
+	Type            string        `json:"Type"`
+	Service         string        `json:"Service,omitempty"`
+	ServiceType     string        `json:"ServiceType,omitempty"`
+	Step            string        `json:"Step,omitempty"` // "build" or "deploy"
+	Status          string        `json:"Status,omitempty"`
+	DurationMs      int64         `json:"DurationMs,omitempty"`
+	Environment     string        `json:"Environment,omitempty"`
+	Image           string        `json:"Image,omitempty"`
+	PreviousImage   string        `json:"PreviousImage,omitempty"`
+	PreviousVersion string        `json:"PreviousVersion,omitempty"`
+	NextVersion     string        `json:"NextVersion,omitempty"`
+	DeploymentYaml  string        `json:"DeploymentYaml,omitempty"`
+	ServiceYaml     string        `json:"ServiceYaml,omitempty"`
+	RolledBack      bool          `json:"RolledBack,omitempty"`
+	LiveImage       string        `json:"LiveImage,omitempty"`   // status events only
+	Ready           string        `json:"Ready,omitempty"`       // status events only, "ready/desired"
+	Restarts        *int64        `json:"Restarts,omitempty"`    // status events only
+	LastRollout     *time.Time    `json:"LastRollout,omitempty"` // status events only
+	Error           *EventError   `json:"Error,omitempty"`
+	Operation       string        `json:"Operation,omitempty"`
+	ExitCode        *int          `json:"ExitCode,omitempty"` // result events only
+	Services        []ServiceItem `json:"Services,omitempty"` // result events only
+}
+
+// EventError describes a failure, Category is one of the Category* constants.
+type EventError struct {
+	Category string `json:"Category"`
+	Message  string `json:"Message"`
+}
+
+// ServiceItem is the outcome of one service in the result event.
+type ServiceItem struct {
+	Service    string      `json:"Service"`
+	Type       string      `json:"Type"`
+	Build      string      `json:"Build"`
+	Deploy     string      `json:"Deploy"`
+	Image      string      `json:"Image,omitempty"`
+	DurationMs int64       `json:"DurationMs"`
+	Error      *EventError `json:"Error,omitempty"`
+}
+
+// EventEmitter writes events as newline-delimited JSON. It is safe for concurrent use.
+type EventEmitter struct {
+	w     io.Writer
+	mutex sync.Mutex
+}
+
+func NewEventEmitter(w io.Writer) *EventEmitter {
+	return &EventEmitter{w: w}
+}
+
+// Emit stamps the event with the current time and writes it as one line.
+func (e *EventEmitter) Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.w.Write(append(line, '\n'))
+}
+
+// The emitter events go to, nil unless --output json is used
+var eventEmitter *EventEmitter
+
+// SetEventEmitter sets where events go, nil turns them off.
+func SetEventEmitter(emitter *EventEmitter) {
+	eventEmitter = emitter
+}
+
+func emitEvent(event Event) {
+	if eventEmitter != nil {
+		eventEmitter.Emit(event)
+	}
+}
+
+// EmitResult writes the final result event of a run. err is the error that ended the run
+// before any service ran, if any.
+func EmitResult(operation string, exitCode int, results []*ServiceResult, err error) {
+	if eventEmitter == nil {
+		return
+	}
+
+	event := Event{
+		Type:      EventResult,
+		Status:    "succeeded",
+		Operation: operation,
+		ExitCode:  &exitCode,
+		Error:     newEventError(err),
+	}
+
+	if exitCode != 0 {
+		event.Status = "failed"
+	}
+
+	for _, result := range results {
+		event.Services = append(event.Services, ServiceItem{
+			Service:    result.Target.Name,
+			Type:       result.Target.Type,
+			Build:      result.Build,
+			Deploy:     result.Deploy,
+			Image:      result.Image,
+			DurationMs: result.Duration.Milliseconds(),
+			Error:      newEventError(result.Err),
+		})
+	}
+
+	eventEmitter.Emit(event)
+}
+
+func newEventError(err error) *EventError {
+	if err == nil {
+		return nil
+	}
+
+	return &EventError{Category: ErrorCategory(err), Message: err.Error()}
+}
+
+// stepFinishedEvent describes the outcome of a build or deploy step of a service.
+func stepFinishedEvent(target ServiceTarget, step, status string, duration time.Duration, image string, err error) Event {
+	var rolloutErr *RolloutError
+
+	return Event{
+		Type:        EventStepFinished,
+		Service:     target.Name,
+		ServiceType: target.Type,
+		Step:        step,
+		Status:      status,
+		DurationMs:  duration.Milliseconds(),
+		Image:       image,
+		RolledBack:  errors.As(err, &rolloutErr) && rolloutErr.RolledBack,
+		Error:       newEventError(err),
+	}
+}
+
+// emitResolvedEvent reports the image and manifests a build or deploy step is going to use.
+func emitResolvedEvent(env *types.EnvironmentConfig, step, serviceType, serviceName, image, previousImage, previousVersion, nextVersion, deploymentYaml, serviceYaml string) {
+	emitEvent(Event{
+		Type:            EventResolved,
+		Service:         serviceName,
+		ServiceType:     serviceType,
+		Step:            step,
+		Environment:     env.Name,
+		Image:           image,
+		PreviousImage:   previousImage,
+		PreviousVersion: previousVersion,
+		NextVersion:     nextVersion,
+		DeploymentYaml:  deploymentYaml,
+		ServiceYaml:     serviceYaml,
+	})
+}
+
+// EmitStatus writes a status event for every service.
+func EmitStatus(statuses []*ServiceStatus) {
+	for _, status := range statuses {
+		event := Event{
+			Type:        EventStatus,
+			Service:     status.Target.Name,
+			ServiceType: status.Target.Type,
+			Status:      status.State,
+			Image:       status.CommittedImage,
+			LiveImage:   status.LiveImage,
+			Error:       newEventError(status.Err),
+		}
+
+		if status.LiveImage != "" {
+			restarts := status.Restarts
+
+			event.Ready = fmt.Sprintf("%d/%d", status.ReadyReplicas, status.DesiredReplicas)
+			event.Restarts = &restarts
+		}
+
+		if !status.LastRollout.IsZero() {
+			lastRollout := status.LastRollout
+
+			event.LastRollout = &lastRollout
+		}
+
+		emitEvent(event)
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// useEventBuffer sends events to a buffer for the duration of the test.
+func useEventBuffer(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	SetEventEmitter(NewEventEmitter(&buffer))
+	t.Cleanup(func() { SetEventEmitter(nil) })
+
+	return &buffer
+}
+
+func decodeEvents(t *testing.T, buffer *bytes.Buffer) []Event {
+	t.Helper()
+
+	var events []Event
+
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var event Event
+
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("event %q is not JSON: %v", line, err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func TestRunPipelineEmitsStepEvents(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+	buffer := useEventBuffer(t)
+
+	results, err := RunPipeline(cfg, &types.Args{DeployTo: "dev"}, cwd, "build", []ServiceTarget{{Name: "image", Type: "go"}}, 1)
+	if err != nil {
+		t.Fatalf("RunPipeline returned an error: %v", err)
+	}
+
+	EmitResult("build", 0, results, nil)
+
+	events := decodeEvents(t, buffer)
+	var eventTypes []string
+
+	for _, event := range events {
+		eventTypes = append(eventTypes, event.Type)
+	}
+
+	if strings.Join(eventTypes, " ") != "step_started resolved step_finished result" {
+		t.Fatalf("unexpected events %q", eventTypes)
+	}
+
+	resolved := events[1]
+
+	if resolved.Image != "udecrypt_image:1.0.2" || resolved.PreviousImage != "udecrypt_image:1.0.1" ||
+		resolved.PreviousVersion != "1.0.1" || resolved.NextVersion != "1.0.2" ||
+		!strings.HasSuffix(resolved.DeploymentYaml, "deployment.dev.yaml") || !strings.HasSuffix(resolved.ServiceYaml, "service.yaml") {
+		t.Errorf("unexpected resolved event %+v", resolved)
+	}
+
+	if finished := events[2]; finished.Step != "build" || finished.Status != stepSucceeded || finished.Image != "udecrypt_image:1.0.2" {
+		t.Errorf("unexpected step_finished event %+v", finished)
+	}
+
+	result := events[3]
+
+	if result.Status != "succeeded" || result.ExitCode == nil || *result.ExitCode != 0 || len(result.Services) != 1 || result.Services[0].Image != "udecrypt_image:1.0.2" {
+		t.Errorf("unexpected result event %+v", result)
+	}
+}
+
+func TestRunPipelineEmitsCategorizedErrors(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("docker build", RecordedOutput{Stderr: "no Dockerfile", Err: errors.New("exit status 1")})
+	buffer := useEventBuffer(t)
+
+	if _, err := RunPipeline(cfg, &types.Args{DeployTo: "dev"}, cwd, "bnd", []ServiceTarget{{Name: "image", Type: "go"}}, 1); err != nil {
+		t.Fatalf("RunPipeline returned an error: %v", err)
+	}
+
+	var finished []Event
+
+	for _, event := range decodeEvents(t, buffer) {
+		if event.Type == EventStepFinished {
+			finished = append(finished, event)
+		}
+	}
+
+	if len(finished) != 2 {
+		t.Fatalf("expected a finished build and deploy step, got %+v", finished)
+	}
+
+	if finished[0].Status != stepFailed || finished[0].Error == nil || finished[0].Error.Category != CategoryBuild {
+		t.Errorf("expected a failed build with a build error, got %+v", finished[0])
+	}
+
+	if finished[1].Step != "deploy" || finished[1].Status != stepSkipped {
+		t.Errorf("expected the deploy to be skipped, got %+v", finished[1])
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gitOutput runs a git command in dir and returns its trimmed standard output.
+func gitOutput(dir string, args ...string) (string, error) {
+	output, errOutput, err := runCommand(Command{Name: "git", Args: args, Dir: dir, Quiet: true})
+
+	if err != nil {
+		return "", fmt.Errorf("[!] `git %s` failed: %s", strings.Join(args, " "), commandError(err, errOutput))
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// gitShortSha returns the abbreviated HEAD commit, suffixed with "-dirty" when dir has
+// uncommitted changes.
+func gitShortSha(dir string) (string, error) {
+	sha, err := gitOutput(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	status, err := gitOutput(dir, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return "", err
+	}
+
+	if status != "" {
+		sha += "-dirty"
+	}
+
+	return sha, nil
+}
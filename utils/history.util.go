@@ -0,0 +1,481 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const (
+	historyFileName = "history.json"
+
+	// Deploys kept per service and environment, a ConfigMap holds at most 1MiB
+	maxHistoryRecords = 50
+)
+
+// Outcomes of a recorded deploy
+const (
+	deploySucceeded  = "succeeded"
+	deployFailed     = "failed"
+	deployRolledBack = "rolled-back" // the rollout failed and the previous image was restored
+)
+
+// What a recorded deploy was started by
+const (
+	deployAction   = "deploy"
+	rollbackAction = "rollback"
+)
+
+// DeployHistory is the local record of every deploy of every service and environment,
+// kept in .k8s-deployer/history.json next to the build state.
+type DeployHistory struct {
+	Services map[string][]DeployRecord `json:"Services"` // keyed by stateKey, oldest first
+}
+
+// DeployRecord is one deploy of a service to an environment.
+type DeployRecord struct {
+	Image        string    `json:"Image"`
+	Version      string    `json:"Version"`
+	ManifestHash string    `json:"ManifestHash"` // sha256 of the applied deployment and service YAML
+	GitCommit    string    `json:"GitCommit"`
+	User         string    `json:"User"`
+	DeployedAt   time.Time `json:"DeployedAt"`
+	Action       string    `json:"Action"`  // "deploy" or "rollback"
+	Outcome      string    `json:"Outcome"` // "succeeded", "failed" or "rolled-back"
+}
+
+func historyFilePath(cwd string) string {
+	return filepath.Join(cwd, stateDirectory, historyFileName)
+}
+
+func readDeployHistory(cwd string) (*DeployHistory, error) {
+	history := &DeployHistory{Services: map[string][]DeployRecord{}}
+
+	data, err := os.ReadFile(historyFilePath(cwd))
+
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("[!] Failed to read the deploy history: %v", err)
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("[!] Failed to parse the deploy history %s: %v", historyFilePath(cwd), err)
+	}
+
+	if history.Services == nil {
+		history.Services = map[string][]DeployRecord{}
+	}
+
+	return history, nil
+}
+
+// deployRecords returns the deploys of a service to an environment, oldest first. They come
+// from the environment's HistoryConfigMap when it has one and it can be read, from the local
+// history file otherwise.
+func deployRecords(env *types.EnvironmentConfig, cwd, serviceName string) ([]DeployRecord, error) {
+	if env.HistoryConfigMap != "" {
+		records, err := readHistoryConfigMap(env, cwd, serviceName)
+
+		if err == nil {
+			return records, nil
+		}
+
+		fmt.Printf("[!] Falling back to the local deploy history: %v\n", err)
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	history, err := readDeployHistory(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return history.Services[stateKey(env.Name, serviceName)], nil
+}
+
+// recordDeploy appends a deploy to the local history and, when the environment has one, to
+// its HistoryConfigMap. Failing to record never fails the deploy itself.
+func recordDeploy(env *types.EnvironmentConfig, cwd, label, serviceName string, record DeployRecord) {
+	stateMutex.Lock()
+
+	history, err := readDeployHistory(cwd)
+
+	if err == nil {
+		key := stateKey(env.Name, serviceName)
+		history.Services[key] = appendDeployRecord(history.Services[key], record)
+
+		var data []byte
+
+		if data, err = json.MarshalIndent(history, "", "  "); err == nil {
+			err = writeFileAtomically(historyFilePath(cwd), append(data, '\n'))
+		}
+	}
+
+	stateMutex.Unlock()
+
+	if err != nil {
+		fmt.Printf("[!] Failed to record the deploy of '%s' in the local history: %v\n", serviceName, err)
+	}
+
+	if env.HistoryConfigMap == "" {
+		return
+	}
+
+	if err := appendToHistoryConfigMap(env, cwd, label, serviceName, record); err != nil {
+		fmt.Printf("[!] Failed to record the deploy of '%s' in ConfigMap '%s': %v\n", serviceName, env.HistoryConfigMap, err)
+	}
+}
+
+func appendDeployRecord(records []DeployRecord, record DeployRecord) []DeployRecord {
+	records = append(records, record)
+
+	if len(records) > maxHistoryRecords {
+		records = records[len(records)-maxHistoryRecords:]
+	}
+
+	return records
+}
+
+// Characters a ConfigMap data key may not contain
+var invalidConfigMapKeyCharacters = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// historyConfigMapKey is the data key of a service's deploys in the environment's ConfigMap.
+func historyConfigMapKey(env *types.EnvironmentConfig, serviceName string) string {
+	return invalidConfigMapKeyCharacters.ReplaceAllString(env.Name+"."+serviceName, "_")
+}
+
+// getHistoryConfigMap returns the data of the environment's HistoryConfigMap, and whether
+// it exists.
+func getHistoryConfigMap(env *types.EnvironmentConfig, cwd string) (map[string]string, bool, error) {
+	cmd := kubectlCommand(env, "get", "configmap", env.HistoryConfigMap, "-o", "json", "--ignore-not-found")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get ConfigMap '%s': %s", env.HistoryConfigMap, commandError(err, errOutput))
+	}
+
+	if strings.TrimSpace(output) == "" {
+		return nil, false, nil
+	}
+
+	var configMap struct {
+		Data map[string]string `json:"data"`
+	}
+
+	if err := json.Unmarshal([]byte(output), &configMap); err != nil {
+		return nil, false, fmt.Errorf("failed to parse ConfigMap '%s': %v", env.HistoryConfigMap, err)
+	}
+
+	return configMap.Data, true, nil
+}
+
+func readHistoryConfigMap(env *types.EnvironmentConfig, cwd, serviceName string) ([]DeployRecord, error) {
+	data, _, err := getHistoryConfigMap(env, cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []DeployRecord
+
+	if value := data[historyConfigMapKey(env, serviceName)]; value != "" {
+		if err := json.Unmarshal([]byte(value), &records); err != nil {
+			return nil, fmt.Errorf("failed to parse the history of '%s' in ConfigMap '%s': %v", serviceName, env.HistoryConfigMap, err)
+		}
+	}
+
+	return records, nil
+}
+
+// appendToHistoryConfigMap adds a deploy to the service's key of the HistoryConfigMap,
+// creating the ConfigMap on the first deploy. Other keys are left alone, so services
+// deployed from different machines share the ConfigMap.
+func appendToHistoryConfigMap(env *types.EnvironmentConfig, cwd, label, serviceName string, record DeployRecord) error {
+	data, exists, err := getHistoryConfigMap(env, cwd)
+	if err != nil {
+		return err
+	}
+
+	key := historyConfigMapKey(env, serviceName)
+
+	var records []DeployRecord
+
+	if value := data[key]; value != "" {
+		// A corrupted value is replaced rather than blocking every later deploy
+		json.Unmarshal([]byte(value), &records)
+	}
+
+	value, err := json.Marshal(appendDeployRecord(records, record))
+	if err != nil {
+		return err
+	}
+
+	var cmd Command
+
+	if exists {
+		patch, err := json.Marshal(map[string]map[string]string{"data": {key: string(value)}})
+		if err != nil {
+			return err
+		}
+
+		cmd = kubectlCommand(env, "patch", "configmap", env.HistoryConfigMap, "--type", "merge", "-p", string(patch))
+	} else {
+		cmd = kubectlCommand(env, "create", "configmap", env.HistoryConfigMap, "--from-literal="+key+"="+string(value))
+	}
+
+	cmd.Dir = cwd
+	cmd.Label = label
+
+	_, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return fmt.Errorf("%s", commandError(err, errOutput))
+	}
+
+	return nil
+}
+
+// newDeployRecord describes a deploy of an image with the manifests it applied.
+func newDeployRecord(cwd, image, action, outcome string, manifestPaths ...string) DeployRecord {
+	gitCommit, _ := gitShortSha(cwd)
+
+	return DeployRecord{
+		Image:        image,
+		Version:      versionOf(image),
+		ManifestHash: manifestHash(manifestPaths...),
+		GitCommit:    gitCommit,
+		User:         currentUser(),
+		DeployedAt:   buildClock().UTC(),
+		Action:       action,
+		Outcome:      outcome,
+	}
+}
+
+// manifestHash hashes the manifests in order, an unreadable one is hashed as empty.
+func manifestHash(manifestPaths ...string) string {
+	hash := sha256.New()
+
+	for _, manifestPath := range manifestPaths {
+		data, _ := os.ReadFile(manifestPath)
+
+		fmt.Fprintf(hash, "%s %d\n", filepath.Base(manifestPath), len(data))
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func currentUser() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// rollbackRecord picks the deploy a rollback returns to: the latest successful deploy of
+// the version or image given with --to, or else the latest successful deploy of an image
+// other than the live one.
+func rollbackRecord(records []DeployRecord, liveImage, to string) (*DeployRecord, error) {
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+
+		if record.Outcome != deploySucceeded {
+			continue
+		}
+
+		if to != "" && (record.Version == to || record.Image == to) {
+			return &record, nil
+		}
+
+		if to == "" && record.Image != liveImage {
+			return &record, nil
+		}
+	}
+
+	if to != "" {
+		return nil, &UsageError{Reason: fmt.Sprintf("No successful deploy of '%s' in the history", to)}
+	}
+
+	return nil, &UsageError{Reason: fmt.Sprintf("No successful deploy of an image other than %s in the history, nothing to roll back to", rollbackTarget(liveImage))}
+}
+
+// Rollback deploys the image of an earlier successful deploy of the service again, see
+// rollbackRecord, and returns it. The deployment YAML is updated to the image, which is
+// not delivered again: it reached the cluster when it was first deployed.
+func Rollback(
+	cfg *types.K8sDeployerConfig,
+	args *types.Args,
+	cwd,
+	serviceName string,
+) (string, error) {
+	serviceType := args.MicroserviceType
+
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return "", err
+	}
+
+	serviceDirectoryRoot, err := GetServiceDirectoryRoot(cfg, cwd, serviceType, serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, env, serviceDirectoryRoot, serviceType, serviceName)
+
+	if err := checkManifestsExist(deploymentYamlPath, serviceYamlPath); err != nil {
+		return "", err
+	}
+
+	records, err := deployRecords(env, cwd, serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, serviceName)
+	if err != nil {
+		return "", err
+	}
+
+	liveImage := getLiveImage(env, serviceDirectoryRoot, workload)
+
+	if liveImage == "" {
+		// Nothing is running, what the history last deployed is what is being rolled back
+		for i := len(records) - 1; i >= 0 && liveImage == ""; i-- {
+			if records[i].Outcome == deploySucceeded {
+				liveImage = records[i].Image
+			}
+		}
+	}
+
+	target, err := rollbackRecord(records, liveImage, args.RollbackTo)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("[+] Rolling '%s' back from %s to %s, deployed %s by %s\n",
+		serviceName, rollbackTarget(liveImage), target.Image, target.DeployedAt.Local().Format(time.RFC1123), target.User)
+
+	emitResolvedEvent(env, "deploy", serviceType, serviceName, target.Image, liveImage, versionOf(liveImage), target.Version, deploymentYamlPath, serviceYamlPath)
+
+	previousDockerImagePath := liveImage
+
+	if args.DryRun {
+		diff, err := PreviewYamlUpdate(deploymentYamlPath, target.Image)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Printf("[dry-run] Would update deployment YAML file: %s\n%s", deploymentYamlPath, diff)
+	} else {
+		previousDockerImagePath = workload.Image
+
+		fmt.Printf("[+] Updating deployment YAML file: %s\n", deploymentYamlPath)
+
+		if err := UpdateYaml(deploymentYamlPath, target.Image); err != nil {
+			return "", err
+		}
+	}
+
+	return target.Image, deploy(cfg, env, deployRequest{
+		ProjectRoot:          cwd,
+		ServiceDirectoryRoot: serviceDirectoryRoot,
+		ServiceName:          serviceName,
+		ServiceType:          serviceType,
+		Image:                target.Image,
+		DeploymentYamlPath:   deploymentYamlPath,
+		ServiceYamlPath:      serviceYamlPath,
+		PreviousImage:        previousDockerImagePath,
+		Action:               rollbackAction,
+		SkipDelivery:         true,
+		Diff:                 args.Diff,
+		Confirm:              args.Confirm,
+		DryRun:               args.DryRun,
+	})
+}
+
+// PrintHistory writes a table with the deploys of a service to the --mode environment,
+// newest first.
+func PrintHistory(w io.Writer, cfg *types.K8sDeployerConfig, args *types.Args, cwd, serviceName string) error {
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return err
+	}
+
+	records, err := deployRecords(env, cwd, serviceName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Deploy history of '%s' in %s:\n", serviceName, env.Name)
+
+	if len(records) == 0 {
+		fmt.Fprintln(w, "  (no deploys recorded yet)")
+
+		return nil
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "DEPLOYED AT\tVERSION\tIMAGE\tACTION\tOUTCOME\tCOMMIT\tUSER\tMANIFESTS")
+
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.DeployedAt.Local().Format("2006-01-02 15:04:05"),
+			orDash(record.Version),
+			record.Image,
+			record.Action,
+			record.Outcome,
+			orDash(record.GitCommit),
+			orDash(record.User),
+			shortHash(record.ManifestHash),
+		)
+	}
+
+	return table.Flush()
+}
+
+// versionOf returns the tag of an image, without ParseVersion's logging and default.
+func versionOf(image string) string {
+	reference, err := ParseImageReference(image)
+	if err != nil {
+		return ""
+	}
+
+	return reference.Tag
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+
+	return orDash(hash)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestRollbackRedeploysThePreviousSuccessfulImage(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	if err := DeployAlone(cfg, args, cwd, "image"); err != nil {
+		t.Fatalf("DeployAlone returned an error: %v", err)
+	}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if err := DeployAfterBuild(cfg, args, buildInfo, "image"); err != nil {
+		t.Fatalf("DeployAfterBuild returned an error: %v", err)
+	}
+
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.2"})
+	commands := len(recorder.Commands())
+
+	image, err := Rollback(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Rollback returned an error: %v", err)
+	}
+
+	if image != "udecrypt_image:1.0.1" {
+		t.Errorf("expected a rollback to udecrypt_image:1.0.1, got %q", image)
+	}
+
+	for _, line := range recorder.CommandLines()[commands:] {
+		if strings.HasPrefix(line, "minikube") {
+			t.Errorf("rollback delivered the image again: %s", line)
+		}
+	}
+
+	if yaml := readTestFile(t, buildInfo.DeploymentYamlPath); yaml != testDeploymentYaml {
+		t.Errorf("deployment YAML was not rolled back:\n%s", yaml)
+	}
+
+	records, err := deployRecords(&types.EnvironmentConfig{Name: "dev"}, cwd, "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 recorded deploys, got %+v", records)
+	}
+
+	last := records[2]
+
+	if last.Action != rollbackAction || last.Outcome != deploySucceeded || last.Version != "1.0.1" || len(last.ManifestHash) != 64 {
+		t.Errorf("unexpected rollback record %+v", last)
+	}
+}
+
+func TestRollbackRecord(t *testing.T) {
+	records := []DeployRecord{
+		{Image: "app:1.0.0", Version: "1.0.0", Outcome: deploySucceeded},
+		{Image: "app:1.0.1", Version: "1.0.1", Outcome: deploySucceeded},
+		{Image: "app:1.0.2", Version: "1.0.2", Outcome: deployRolledBack},
+	}
+
+	if record, err := rollbackRecord(records, "app:1.0.1", ""); err != nil || record.Image != "app:1.0.0" {
+		t.Errorf("expected app:1.0.0 before the live image, got %+v, %v", record, err)
+	}
+
+	if record, err := rollbackRecord(records, "app:1.0.1", "1.0.1"); err != nil || record.Image != "app:1.0.1" {
+		t.Errorf("expected --to 1.0.1 to pick app:1.0.1, got %+v, %v", record, err)
+	}
+
+	var usageErr *UsageError
+
+	if _, err := rollbackRecord(records, "app:1.0.1", "1.0.2"); !errors.As(err, &usageErr) {
+		t.Errorf("expected a *UsageError for a version that never deployed successfully, got %v", err)
+	}
+}
+
+func TestRecordDeploySharesTheHistoryConfigMap(t *testing.T) {
+	cwd := t.TempDir()
+	recorder := useRecordingRunner(t)
+	env := &types.EnvironmentConfig{Name: "dev", Namespace: "apps", HistoryConfigMap: "deploy-history"}
+
+	recordDeploy(env, cwd, "go/image", "image", DeployRecord{Image: "app:1.0.0", Outcome: deploySucceeded})
+
+	recorder.On("kubectl --namespace apps get configmap deploy-history", RecordedOutput{
+		Stdout: `{"data":{"dev.other":"[]","dev.image":"[{\"Image\":\"app:1.0.0\",\"Outcome\":\"succeeded\"}]"}}`,
+	})
+
+	recordDeploy(env, cwd, "go/image", "image", DeployRecord{Image: "app:1.0.1", Outcome: deploySucceeded})
+
+	lines := recorder.CommandLines()
+
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "kubectl --namespace apps create configmap deploy-history --from-literal=dev.image=") {
+		t.Fatalf("expected the ConfigMap to be created on the first deploy, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	if !strings.HasPrefix(lines[3], "kubectl --namespace apps patch configmap deploy-history --type merge -p {\"data\":{\"dev.image\":") ||
+		!strings.Contains(lines[3], "app:1.0.0") || !strings.Contains(lines[3], "app:1.0.1") || strings.Contains(lines[3], "dev.other") {
+		t.Errorf("expected only the service's key to be patched, got %s", lines[3])
+	}
+
+	history, err := readDeployHistory(cwd)
+	if err != nil || len(history.Services["dev/image"]) != 2 {
+		t.Errorf("expected both deploys in the local history too, got %+v, %v", history, err)
+	}
+}
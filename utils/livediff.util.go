@@ -0,0 +1,591 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Metadata the API server fills in, never worth showing
+var serverMetadataFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"}
+
+// Paths of container images, the only change --confirm lets through without asking
+var imageFieldPath = regexp.MustCompile(`^spec\.(jobTemplate\.spec\.)?template\.spec\.(initContainers|containers)\[[^\]]+\]\.image$`)
+
+// Paths of resource quantities, which the API server returns in canonical form: `cpu: 1`
+// comes back as "1", `1000m` as "1" and `1024Mi` as "1Gi"
+var quantityFieldPath = regexp.MustCompile(`(^|\.)(resources\.(limits|requests)|overhead|hard|default|defaultRequest|max|min)(\.[^.\[]+|\["[^"]*"\])$|\.sizeLimit$`)
+
+// A quantity is a decimal number with an optional binary or decimal SI suffix
+var quantityPattern = regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?)(Ki|Mi|Gi|Ti|Pi|Ei|n|u|m|k|M|G|T|P|E)?$`)
+
+var quantitySuffixes = map[string]*big.Rat{
+	"":   big.NewRat(1, 1),
+	"n":  big.NewRat(1, 1_000_000_000),
+	"u":  big.NewRat(1, 1_000_000),
+	"m":  big.NewRat(1, 1_000),
+	"k":  big.NewRat(1_000, 1),
+	"M":  big.NewRat(1_000_000, 1),
+	"G":  big.NewRat(1_000_000_000, 1),
+	"T":  big.NewRat(1_000_000_000_000, 1),
+	"P":  big.NewRat(1_000_000_000_000_000, 1),
+	"E":  big.NewRat(1_000_000_000_000_000_000, 1),
+	"Ki": big.NewRat(1<<10, 1),
+	"Mi": big.NewRat(1<<20, 1),
+	"Gi": big.NewRat(1<<30, 1),
+	"Ti": big.NewRat(1<<40, 1),
+	"Pi": big.NewRat(1<<50, 1),
+	"Ei": big.NewRat(1<<60, 1),
+}
+
+// FieldChange is one field that differs between a local manifest and the live object.
+type FieldChange struct {
+	Op   string // "+" only in the manifest, "-" only in the cluster, "~" changed
+	Path string // e.g. "spec.template.spec.containers[api].image"
+	Old  any    // live value
+	New  any    // manifest value
+}
+
+// ObjectDiff is the difference between one object of a manifest and the cluster.
+type ObjectDiff struct {
+	Kind     string
+	Name     string
+	Manifest string
+	Created  bool // the object is not in the cluster yet
+	Changes  []FieldChange
+}
+
+// ImageOnly reports whether applying the object changes nothing but container images.
+func (d *ObjectDiff) ImageOnly() bool {
+	if d.Created {
+		return false
+	}
+
+	for _, change := range d.Changes {
+		if !imageFieldPath.MatchString(change.Path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// diffManifest compares every object of a manifest with the live object of the same kind
+// and name. Fields the server populated are ignored: the status, server metadata, and any
+// field that is neither in the manifest nor in the configuration last applied by kubectl,
+// which covers the defaults filled in on creation.
+func diffManifest(env *types.EnvironmentConfig, cwd, manifestPath string) ([]*ObjectDiff, error) {
+	objects, err := decodeManifestObjects(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := kubectlCommand(env, "get", "-f", manifestPath, "-o", "json", "--ignore-not-found")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("[!] Failed to get the live objects of %s: %s", manifestPath, commandError(err, errOutput))
+	}
+
+	liveObjects, err := decodeLiveObjects(output)
+	if err != nil {
+		return nil, fmt.Errorf("[!] Failed to parse the live objects of %s: %v", manifestPath, err)
+	}
+
+	var diffs []*ObjectDiff
+
+	for _, object := range objects {
+		kind, name := objectKindAndName(object)
+		diff := &ObjectDiff{Kind: kind, Name: name, Manifest: manifestPath}
+
+		live := findObject(liveObjects, kind, name)
+
+		if live == nil {
+			diff.Created = true
+		} else {
+			applied := lastAppliedConfiguration(live)
+			stripServerFields(live)
+
+			// The namespace usually comes from kubectl's --namespace rather than the manifest
+			if metadata, _ := object["metadata"].(map[string]any); metadata != nil && metadata["namespace"] == nil {
+				if liveMetadata, _ := live["metadata"].(map[string]any); liveMetadata != nil {
+					delete(liveMetadata, "namespace")
+				}
+			}
+
+			compareFields("", object, live, applied, &diff.Changes)
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
+
+// decodeManifestObjects reads every document of a manifest as JSON-typed values, so they
+// compare equal to what kubectl returns.
+func decodeManifestObjects(manifestPath string) ([]map[string]any, error) {
+	data, err := readManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := decodeYamlDocuments(data)
+	if err != nil {
+		return nil, &ManifestError{Path: manifestPath, Reason: fmt.Sprintf("error parsing YAML file: %v", err)}
+	}
+
+	var objects []map[string]any
+
+	for _, document := range documents {
+		var value any
+
+		if err := document.Decode(&value); err != nil {
+			return nil, &ManifestError{Path: manifestPath, Reason: fmt.Sprintf("error parsing YAML file: %v", err)}
+		}
+
+		if value == nil {
+			continue
+		}
+
+		var object map[string]any
+
+		if data, err := json.Marshal(value); err != nil || json.Unmarshal(data, &object) != nil {
+			return nil, &ManifestError{Path: manifestPath, Reason: "a document is not a Kubernetes object"}
+		}
+
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// decodeLiveObjects parses `kubectl get -o json`, a single object or a List.
+func decodeLiveObjects(output string) ([]map[string]any, error) {
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+
+	var object map[string]any
+	if err := json.Unmarshal([]byte(output), &object); err != nil {
+		return nil, err
+	}
+
+	if object["kind"] != "List" {
+		return []map[string]any{object}, nil
+	}
+
+	var objects []map[string]any
+
+	items, _ := object["items"].([]any)
+
+	for _, item := range items {
+		if itemObject, ok := item.(map[string]any); ok {
+			objects = append(objects, itemObject)
+		}
+	}
+
+	return objects, nil
+}
+
+func objectKindAndName(object map[string]any) (string, string) {
+	kind, _ := object["kind"].(string)
+	metadata, _ := object["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+
+	return kind, name
+}
+
+func findObject(objects []map[string]any, kind, name string) map[string]any {
+	for _, object := range objects {
+		if objectKind, objectName := objectKindAndName(object); objectKind == kind && objectName == name {
+			return object
+		}
+	}
+
+	return nil
+}
+
+func lastAppliedConfiguration(live map[string]any) map[string]any {
+	metadata, _ := live["metadata"].(map[string]any)
+	annotations, _ := metadata["annotations"].(map[string]any)
+	value, _ := annotations[lastAppliedAnnotation].(string)
+
+	var applied map[string]any
+	json.Unmarshal([]byte(value), &applied)
+
+	return applied
+}
+
+func stripServerFields(live map[string]any) {
+	delete(live, "status")
+
+	metadata, _ := live["metadata"].(map[string]any)
+
+	for _, field := range serverMetadataFields {
+		delete(metadata, field)
+	}
+
+	if annotations, _ := metadata["annotations"].(map[string]any); annotations != nil {
+		delete(annotations, lastAppliedAnnotation)
+	}
+}
+
+// compareFields appends the differences between a manifest value and the live value.
+// applied is the same value in the last applied configuration, it decides whether a field
+// missing from the manifest was removed from it or was filled in by the server.
+func compareFields(path string, manifest, live, applied any, changes *[]FieldChange) {
+	switch manifestValue := manifest.(type) {
+	case map[string]any:
+		liveValue, ok := live.(map[string]any)
+		if !ok {
+			break
+		}
+
+		appliedValue, _ := applied.(map[string]any)
+
+		for _, key := range unionKeys(manifestValue, liveValue) {
+			fieldPath := joinFieldPath(path, key)
+			manifestField, inManifest := manifestValue[key]
+			liveField, inCluster := liveValue[key]
+
+			switch {
+			case inManifest && inCluster:
+				compareFields(fieldPath, manifestField, liveField, appliedValue[key], changes)
+			case inManifest:
+				*changes = append(*changes, FieldChange{Op: "+", Path: fieldPath, New: manifestField})
+			case appliedValue[key] != nil:
+				*changes = append(*changes, FieldChange{Op: "-", Path: fieldPath, Old: liveField})
+			}
+		}
+
+		return
+	case []any:
+		liveValue, ok := live.([]any)
+		if !ok {
+			break
+		}
+
+		appliedValue, _ := applied.([]any)
+
+		compareLists(path, manifestValue, liveValue, appliedValue, changes)
+
+		return
+	}
+
+	if reflect.DeepEqual(manifest, live) {
+		return
+	}
+
+	if quantityFieldPath.MatchString(path) && equalQuantities(manifest, live) {
+		return
+	}
+
+	*changes = append(*changes, FieldChange{Op: "~", Path: path, Old: live, New: manifest})
+}
+
+// equalQuantities reports whether two resource quantities, numbers or strings, are the
+// same amount.
+func equalQuantities(a, b any) bool {
+	aQuantity, ok := parseQuantity(a)
+	if !ok {
+		return false
+	}
+
+	bQuantity, ok := parseQuantity(b)
+
+	return ok && aQuantity.Cmp(bQuantity) == 0
+}
+
+func parseQuantity(value any) (*big.Rat, bool) {
+	var text string
+
+	switch value := value.(type) {
+	case float64:
+		return new(big.Rat).SetFloat64(value), true
+	case string:
+		text = strings.TrimSpace(value)
+	default:
+		return nil, false
+	}
+
+	match := quantityPattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil, false
+	}
+
+	number, ok := new(big.Rat).SetString(match[1])
+	if !ok {
+		return nil, false
+	}
+
+	return number.Mul(number, quantitySuffixes[match[5]]), true
+}
+
+// compareLists matches the elements of lists of named objects (containers, ports, env...)
+// by name and other lists by index.
+func compareLists(path string, manifest, live, applied []any, changes *[]FieldChange) {
+	if names, ok := elementNames(manifest); ok {
+		if liveNames, ok := elementNames(live); ok {
+			appliedNames, _ := elementNames(applied)
+
+			for i, name := range names {
+				fieldPath := fmt.Sprintf("%s[%s]", path, name)
+
+				if j := indexOf(liveNames, name); j >= 0 {
+					var appliedElement any
+
+					if k := indexOf(appliedNames, name); k >= 0 {
+						appliedElement = applied[k]
+					}
+
+					compareFields(fieldPath, manifest[i], live[j], appliedElement, changes)
+				} else {
+					*changes = append(*changes, FieldChange{Op: "+", Path: fieldPath, New: manifest[i]})
+				}
+			}
+
+			for j, name := range liveNames {
+				if indexOf(names, name) < 0 && indexOf(appliedNames, name) >= 0 {
+					*changes = append(*changes, FieldChange{Op: "-", Path: fmt.Sprintf("%s[%s]", path, name), Old: live[j]})
+				}
+			}
+
+			return
+		}
+	}
+
+	for i := range manifest {
+		fieldPath := fmt.Sprintf("%s[%d]", path, i)
+
+		if i < len(live) {
+			var appliedElement any
+
+			if i < len(applied) {
+				appliedElement = applied[i]
+			}
+
+			compareFields(fieldPath, manifest[i], live[i], appliedElement, changes)
+		} else {
+			*changes = append(*changes, FieldChange{Op: "+", Path: fieldPath, New: manifest[i]})
+		}
+	}
+
+	for i := len(manifest); i < len(live) && i < len(applied); i++ {
+		*changes = append(*changes, FieldChange{Op: "-", Path: fmt.Sprintf("%s[%d]", path, i), Old: live[i]})
+	}
+}
+
+// elementNames returns the "name" of every element, if they are all named objects.
+func elementNames(list []any) ([]string, bool) {
+	names := make([]string, len(list))
+
+	for i, element := range list {
+		object, _ := element.(map[string]any)
+		name, ok := object["name"].(string)
+
+		if !ok {
+			return nil, false
+		}
+
+		names[i] = name
+	}
+
+	return names, len(list) > 0
+}
+
+func indexOf(values []string, value string) int {
+	for i, candidate := range values {
+		if candidate == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func unionKeys(a, b map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+
+	for key := range a {
+		keys = append(keys, key)
+	}
+
+	for key := range b {
+		if _, found := a[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+
+	return path + "." + key
+}
+
+// PrintObjectDiffs writes the field-level changes of every object.
+func PrintObjectDiffs(w io.Writer, diffs []*ObjectDiff) {
+	for _, diff := range diffs {
+		object := strings.ToLower(diff.Kind) + "/" + diff.Name
+
+		switch {
+		case diff.Created:
+			fmt.Fprintf(w, "[diff] %s: not in the cluster yet, will be created from %s\n", object, diff.Manifest)
+		case len(diff.Changes) == 0:
+			fmt.Fprintf(w, "[diff] %s: no changes\n", object)
+		default:
+			fmt.Fprintf(w, "[diff] %s:\n", object)
+
+			for _, change := range diff.Changes {
+				switch change.Op {
+				case "+":
+					fmt.Fprintf(w, "    + %s: %s\n", change.Path, formatFieldValue(change.New))
+				case "-":
+					fmt.Fprintf(w, "    - %s: %s\n", change.Path, formatFieldValue(change.Old))
+				default:
+					fmt.Fprintf(w, "    ~ %s: %s -> %s\n", change.Path, formatFieldValue(change.Old), formatFieldValue(change.New))
+				}
+			}
+		}
+	}
+}
+
+func formatFieldValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
+
+// DiffManifests compares the deployment, service and extra manifests of a service with the
+// objects running in the --mode environment's cluster.
+func DiffManifests(cfg *types.K8sDeployerConfig, args *types.Args, cwd, serviceName string) ([]*ObjectDiff, error) {
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceDirectoryRoot, err := GetServiceDirectoryRoot(cfg, cwd, args.MicroserviceType, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentYamlPath, serviceYamlPath := GetDeploymentAndServiceYamlPaths(cfg, env, serviceDirectoryRoot, args.MicroserviceType, serviceName)
+
+	if err := checkManifestsExist(deploymentYamlPath, serviceYamlPath); err != nil {
+		return nil, err
+	}
+
+	serviceConfig, err := GetServiceConfig(cfg, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	extraManifests, err := resolveExtraManifests(env, serviceConfig, filepath.Dir(deploymentYamlPath), deploymentYamlPath, serviceYamlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffManifests(env, serviceDirectoryRoot, append([]string{deploymentYamlPath, serviceYamlPath}, manifestPaths(extraManifests)...)...)
+}
+
+func diffManifests(env *types.EnvironmentConfig, cwd string, manifestPaths ...string) ([]*ObjectDiff, error) {
+	var diffs []*ObjectDiff
+
+	for _, manifestPath := range manifestPaths {
+		manifestDiffs, err := diffManifest(env, cwd, manifestPath)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs = append(diffs, manifestDiffs...)
+	}
+
+	return diffs, nil
+}
+
+// reviewManifests prints the diff of the manifests about to be applied and, with confirm,
+// asks for approval unless nothing but container images changes.
+func reviewManifests(env *types.EnvironmentConfig, cwd, serviceName string, confirm bool, manifestPaths ...string) error {
+	diffs, err := diffManifests(env, cwd, manifestPaths...)
+	if err != nil {
+		return &DeployError{ServiceName: serviceName, Err: err}
+	}
+
+	PrintObjectDiffs(os.Stdout, diffs)
+
+	if !confirm {
+		return nil
+	}
+
+	imageOnly := true
+
+	for _, diff := range diffs {
+		imageOnly = imageOnly && diff.ImageOnly()
+	}
+
+	if imageOnly {
+		fmt.Println("[+] Only container images change, no confirmation needed")
+
+		return nil
+	}
+
+	approved, err := confirmDeploy(fmt.Sprintf("Apply these changes to '%s' in %s?", serviceName, env.Name))
+
+	if err != nil {
+		return &DeployError{ServiceName: serviceName, Err: err}
+	}
+
+	if !approved {
+		return &DeployError{ServiceName: serviceName, Err: fmt.Errorf("[!] The changes to '%s' were not approved, nothing was applied", serviceName)}
+	}
+
+	return nil
+}
+
+// confirmDeploy asks a yes/no question on the terminal; tests replace it.
+var confirmDeploy = func(question string) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("[!] --confirm needs an interactive terminal to ask for approval")
+	}
+
+	fmt.Printf("[?] %s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// liveDeployment is the test deployment as the cluster returns it: running image, with
+// status, server metadata and defaults, and an env var that was applied before.
+func liveDeployment(image string) string {
+	applied := `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"udecrypt-image-service-deployment"},` +
+		`"spec":{"template":{"spec":{"containers":[{"name":"udecrypt-image-service","env":[{"name":"LOG_LEVEL","value":"debug"},{"name":"OLD","value":"1"}]}]}}}}`
+
+	return `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"udecrypt-image-service-deployment","namespace":"default",` +
+		`"uid":"1234","resourceVersion":"99","generation":4,"creationTimestamp":"2024-03-01T12:30:00Z",` +
+		`"annotations":{"deployment.kubernetes.io/revision":"4","kubectl.kubernetes.io/last-applied-configuration":` + jsonString(applied) + `}},` +
+		`"spec":{"replicas":1,"progressDeadlineSeconds":600,"selector":{"matchLabels":{"app":"udecrypt-image-service"}},` +
+		`"template":{"metadata":{"labels":{"app":"udecrypt-image-service"}},"spec":{"dnsPolicy":"ClusterFirst","containers":[{"name":"udecrypt-image-service",` +
+		`"image":"` + image + `","imagePullPolicy":"IfNotPresent","env":[{"name":"LOG_LEVEL","value":"debug"},{"name":"OLD","value":"1"}]}]}}},` +
+		`"status":{"replicas":1,"readyReplicas":1}}`
+}
+
+func jsonString(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func TestDiffManifestIgnoresServerPopulatedFields(t *testing.T) {
+	cwd, _ := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get -f", RecordedOutput{Stdout: liveDeployment("udecrypt_image:1.0.0")})
+
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+
+	diffs, err := diffManifest(&types.EnvironmentConfig{Name: "dev"}, cwd, deploymentYamlPath)
+	if err != nil {
+		t.Fatalf("diffManifest returned an error: %v", err)
+	}
+
+	if len(diffs) != 1 || diffs[0].Created {
+		t.Fatalf("expected one existing object, got %+v", diffs)
+	}
+
+	var paths []string
+
+	for _, change := range diffs[0].Changes {
+		paths = append(paths, change.Op+" "+change.Path)
+	}
+
+	expected := []string{
+		"- spec.template.spec.containers[udecrypt-image-service].env[OLD]",
+		"~ spec.template.spec.containers[udecrypt-image-service].image",
+	}
+
+	if strings.Join(paths, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected changes:\n%s\nexpected:\n%s", strings.Join(paths, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if diffs[0].ImageOnly() {
+		t.Errorf("a removed env var is more than an image change")
+	}
+}
+
+func TestDeployConfirmOnlyAsksForMoreThanImageChanges(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	previousConfirm := confirmDeploy
+	t.Cleanup(func() { confirmDeploy = previousConfirm })
+
+	asked := 0
+	confirmDeploy = func(string) (bool, error) {
+		asked++
+		return false, nil
+	}
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go", Confirm: true}
+
+	// The service is not in the cluster yet, so creating it needs approval
+	err := DeployAlone(cfg, args, cwd, "image")
+
+	var deployErr *DeployError
+	if !errors.As(err, &deployErr) || asked != 1 {
+		t.Fatalf("expected a declined *DeployError after one question, got %v (asked %d times)", err, asked)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl apply") || strings.HasPrefix(line, "minikube") {
+			t.Errorf("%q ran although the changes were declined", line)
+		}
+	}
+
+	live := strings.Replace(liveDeployment("udecrypt_image:1.0.0"), `,{"name":"OLD","value":"1"}`, "", -1)
+	recorder.On("kubectl get -f "+filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml"), RecordedOutput{Stdout: live})
+	recorder.On("kubectl get -f "+filepath.Join(cwd, "services", "go", "image", "k8s", "service.yaml"), RecordedOutput{
+		Stdout: `{"apiVersion":"v1","kind":"Service","metadata":{"name":"udecrypt-image-service"},` +
+			`"spec":{"selector":{"app":"udecrypt-image-service"},"ports":[{"port":80}],"clusterIP":"10.0.0.7"}}`,
+	})
+
+	if err := DeployAlone(cfg, args, cwd, "image"); err != nil {
+		t.Fatalf("an image-only change was not applied: %v", err)
+	}
+
+	if asked != 1 {
+		t.Errorf("expected no question for an image-only change, asked %d times", asked)
+	}
+}
+
+func TestDiffManifestComparesResourceQuantitiesByAmount(t *testing.T) {
+	cwd, _ := newTestProject(t)
+	recorder := useRecordingRunner(t)
+
+	manifestPath := filepath.Join(cwd, "services", "go", "image", "k8s", "worker.yaml")
+	writeTestFile(t, manifestPath, `apiVersion: v1
+kind: Pod
+metadata:
+  name: image-worker
+spec:
+  containers:
+    - name: worker
+      image: udecrypt_image:1.0.1
+      env:
+        - name: RATIO
+          value: "1"
+      resources:
+        limits:
+          cpu: 1
+          memory: 1024Mi
+          nvidia.com/gpu: 1
+        requests:
+          cpu: 1000m
+          memory: 256Mi
+  volumes:
+    - name: scratch
+      emptyDir:
+        sizeLimit: 0.5Gi
+`)
+
+	// The cluster returns quantities in canonical form
+	recorder.On("kubectl get -f", RecordedOutput{
+		Stdout: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"image-worker"},"spec":{"containers":[{"name":"worker",` +
+			`"image":"udecrypt_image:1.0.1","env":[{"name":"RATIO","value":"1.0"}],"resources":{` +
+			`"limits":{"cpu":"1","memory":"1Gi","nvidia.com/gpu":"1"},"requests":{"cpu":"1","memory":"512Mi"}}}],` +
+			`"volumes":[{"name":"scratch","emptyDir":{"sizeLimit":"512Mi"}}]}}`,
+	})
+
+	diffs, err := diffManifest(&types.EnvironmentConfig{Name: "dev"}, cwd, manifestPath)
+	if err != nil {
+		t.Fatalf("diffManifest returned an error: %v", err)
+	}
+
+	if len(diffs) != 1 {
+		t.Fatalf("expected one object, got %+v", diffs)
+	}
+
+	var paths []string
+
+	for _, change := range diffs[0].Changes {
+		paths = append(paths, change.Op+" "+change.Path)
+	}
+
+	// Only the memory request really changes; env values are strings, not quantities
+	expected := []string{
+		"~ spec.containers[worker].env[RATIO].value",
+		"~ spec.containers[worker].resources.requests.memory",
+	}
+
+	if strings.Join(paths, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected changes:\n%s\nexpected:\n%s", strings.Join(paths, "\n"), strings.Join(expected, "\n"))
+	}
+}
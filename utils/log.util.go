@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Older run logs are deleted when a new run starts
+const maxRunLogs = 20
+
+// RunLog copies everything the process writes to its standard output and standard error,
+// including the streamed output of the commands it runs, into a log file under
+// .k8s-deployer/logs.
+type RunLog struct {
+	Path string
+
+	file           *os.File
+	stdout, stderr *os.File
+	pipes          []*os.File
+	copying        sync.WaitGroup
+}
+
+// StartRunLog redirects the standard output and standard error of the process through a
+// new log file until Close is called.
+func StartRunLog(cwd string) (*RunLog, error) {
+	directory := filepath.Join(cwd, stateDirectory, "logs")
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("[!] Failed to create the log directory: %v", err)
+	}
+
+	pruneRunLogs(directory, maxRunLogs-1)
+
+	file, err := os.CreateTemp(directory, "run-"+time.Now().Format("20060102-150405")+"-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("[!] Failed to create the run log: %v", err)
+	}
+
+	runLog := &RunLog{Path: file.Name(), file: file, stdout: os.Stdout, stderr: os.Stderr}
+	logWriter := &lockedWriter{w: file}
+
+	for _, stream := range []**os.File{&os.Stdout, &os.Stderr} {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			runLog.Close()
+			return nil, fmt.Errorf("[!] Failed to create the run log: %v", err)
+		}
+
+		destination := io.MultiWriter(*stream, logWriter)
+		*stream = writer
+		runLog.pipes = append(runLog.pipes, writer)
+
+		runLog.copying.Add(1)
+
+		go func() {
+			defer runLog.copying.Done()
+
+			io.Copy(destination, reader)
+			reader.Close()
+		}()
+	}
+
+	return runLog, nil
+}
+
+// Close restores the standard output and standard error and finishes the log file.
+func (l *RunLog) Close() error {
+	os.Stdout, os.Stderr = l.stdout, l.stderr
+
+	for _, pipe := range l.pipes {
+		pipe.Close()
+	}
+
+	l.copying.Wait()
+
+	return l.file.Close()
+}
+
+// pruneRunLogs deletes the oldest run logs beyond the newest keep ones. The timestamped
+// names sort chronologically.
+func pruneRunLogs(directory string, keep int) {
+	logs, err := filepath.Glob(filepath.Join(directory, "run-*.log"))
+	if err != nil || len(logs) <= keep {
+		return
+	}
+
+	sort.Strings(logs)
+
+	for _, log := range logs[:len(logs)-keep] {
+		os.Remove(log)
+	}
+}
+
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *lockedWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.w.Write(data)
+}
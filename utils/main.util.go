@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 
 	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
 	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
@@ -14,67 +15,113 @@ func ParseConfig(cwd string) (*types.K8sDeployerConfig, error) {
 	configFilePath := path.Join(cwd, "/", constants.ConfigFileName)
 
 	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("`%s` file not found", constants.ConfigFileName)
+		return nil, &ConfigError{Reason: fmt.Sprintf("`%s` file not found", constants.ConfigFileName)}
 	}
 
 	configJsonBytes, readErr := os.ReadFile(configFilePath)
 
 	if readErr != nil {
-		return nil, fmt.Errorf("failed to read the `%s`: %s", constants.ConfigFileName, readErr.Error())
+		return nil, &ConfigError{Reason: fmt.Sprintf("failed to read the `%s`: %s", constants.ConfigFileName, readErr.Error())}
 	}
 
 	var config types.K8sDeployerConfig
 	err := json.Unmarshal(configJsonBytes, &config)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse the %s: %s", constants.ConfigFileName, err.Error())
+		return nil, &ConfigError{Reason: fmt.Sprintf("failed to parse the %s: %s", constants.ConfigFileName, err.Error())}
 	}
 
 	return &config, nil
 }
 
-func GetServiceDirectoryRoot(cfg *types.K8sDeployerConfig, cwd, serviceType, serviceName string) string {
-	serviceDirectoryRoot := ""
-
-	if serviceType == constants.Go {
-		exists := cfg.ServicesDirectory.All.Go[serviceName] != ""
-
-		if !exists {
-			fmt.Printf("[!] Service %s not found in the configured paths.\n", serviceName)
-			os.Exit(1)
-		}
-
-		serviceDirectoryRoot = path.Join(
-			cwd,
-			cfg.ServicesDirectory.Root.Go,
-			cfg.ServicesDirectory.All.Go[serviceName],
-		)
-	} else if serviceType == constants.Dotnet {
-		exists := cfg.ServicesDirectory.All.Dotnet[serviceName] != ""
-
-		if !exists {
-			fmt.Printf("[!] Service %s not found in the configured paths.\n", serviceName)
-			os.Exit(1)
-		}
-
-		serviceDirectoryRoot = path.Join(
-			cwd,
-			cfg.ServicesDirectory.Root.Dotnet,
-			cfg.ServicesDirectory.All.Dotnet[serviceName],
-		)
-	} else {
-		fmt.Printf("[!] Unknown service type: %s\n", serviceType)
-		os.Exit(1)
+func GetServiceDirectoryRoot(cfg *types.K8sDeployerConfig, cwd, serviceType, serviceName string) (string, error) {
+	if _, err := GetBuilder(serviceType); err != nil {
+		return "", err
 	}
 
+	services, _ := lookupByType(cfg.ServicesDirectory.All, serviceType)
+	exists := services[serviceName] != ""
+
+	if !exists {
+		return "", &ServiceNotFoundError{ServiceName: serviceName, ServiceType: serviceType}
+	}
+
+	servicesRoot, _ := lookupByType(cfg.ServicesDirectory.Root, serviceType)
+
+	serviceDirectoryRoot := path.Join(
+		cwd,
+		servicesRoot,
+		services[serviceName],
+	)
+
 	info, err := os.Stat(serviceDirectoryRoot)
 	if os.IsNotExist(err) {
-		fmt.Printf("[!] Service directory not found: %s\n", serviceDirectoryRoot)
-		os.Exit(1)
+		return "", &ServiceDirectoryError{Path: serviceDirectoryRoot, Reason: "not found"}
+	} else if err != nil {
+		return "", &ServiceDirectoryError{Path: serviceDirectoryRoot, Reason: err.Error()}
 	} else if !info.IsDir() {
-		fmt.Printf("[!] Service directory is not a directory: %s\n", serviceDirectoryRoot)
-		os.Exit(1)
+		return "", &ServiceDirectoryError{Path: serviceDirectoryRoot, Reason: "is not a directory"}
+	}
+
+	return serviceDirectoryRoot, nil
+}
+
+// GetServiceConfig returns the per-service settings of the given service with defaults applied.
+func GetServiceConfig(cfg *types.K8sDeployerConfig, serviceName string) (types.ServiceConfig, error) {
+	serviceConfig := cfg.Services[serviceName]
+
+	switch serviceConfig.Strategy {
+	case "":
+		serviceConfig.Strategy = constants.RollingStrategy
+	case constants.RollingStrategy, constants.RecreateStrategy:
+	default:
+		return serviceConfig, &ConfigError{Reason: fmt.Sprintf(
+			"Unknown deployment strategy '%s' for %s (expected %s or %s)",
+			serviceConfig.Strategy,
+			serviceName,
+			constants.RollingStrategy,
+			constants.RecreateStrategy,
+		)}
+	}
+
+	if serviceConfig.RolloutTimeout == "" {
+		serviceConfig.RolloutTimeout = defaultRolloutTimeout
+	}
+
+	if _, err := time.ParseDuration(serviceConfig.RolloutTimeout); err != nil {
+		return serviceConfig, &ConfigError{Reason: fmt.Sprintf(
+			"Invalid rollout timeout '%s' for %s: %v",
+			serviceConfig.RolloutTimeout,
+			serviceName,
+			err,
+		)}
+	}
+
+	versioning := &serviceConfig.Versioning
+
+	switch versioning.Strategy {
+	case "":
+		versioning.Strategy = constants.SemVerVersioning
+	case constants.SemVerVersioning,
+		constants.GitShaVersioning,
+		constants.TimestampVersioning,
+		constants.CalVerVersioning,
+		constants.ConventionalCommitsVersioning:
+	default:
+		return serviceConfig, &ConfigError{Reason: fmt.Sprintf("Unknown versioning strategy '%s' for %s", versioning.Strategy, serviceName)}
+	}
+
+	if versioning.PrereleaseIdentifier == "" {
+		versioning.PrereleaseIdentifier = "rc"
+	}
+
+	if versioning.TimestampFormat == "" {
+		versioning.TimestampFormat = defaultTimestampFormat
+	}
+
+	if versioning.TagPattern == "" {
+		versioning.TagPattern = "*"
 	}
 
-	return serviceDirectoryRoot
+	return serviceConfig, nil
 }
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Kinds in the order they are applied: what others reference (namespaces, accounts,
+// configuration, storage, RBAC) first, then services and workloads, then what points at
+// workloads. Kinds not listed, such as ServiceMonitor, are applied last.
+var manifestKindOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+}
+
+// Extensions of the manifest files picked up from a directory
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// extraManifest is an extra manifest file with the kinds of the objects it holds.
+type extraManifest struct {
+	Path  string
+	Kinds []string
+	Rank  int // position of its earliest kind in manifestKindOrder
+}
+
+func manifestKindRank(kind string) int {
+	for i, orderedKind := range manifestKindOrder {
+		if orderedKind == kind {
+			return i
+		}
+	}
+
+	return len(manifestKindOrder)
+}
+
+// resolveExtraManifests lists the service's extra manifest files for the environment,
+// ordered by kind. A file holding several kinds is applied at the position of its earliest
+// kind, keeping its documents in their order. The deployment and service YAML are left out
+// when a directory contains them.
+func resolveExtraManifests(env *types.EnvironmentConfig, serviceConfig types.ServiceConfig, kubernetesDirectory string, skip ...string) ([]extraManifest, error) {
+	paths := append([]string{}, serviceConfig.Manifests.Paths...)
+
+	if environmentPaths, found := lookupEnvironmentKey(serviceConfig.Manifests.Environments, env.Name); found {
+		paths = append(paths, environmentPaths...)
+	}
+
+	seen := map[string]bool{}
+
+	for _, skipped := range skip {
+		seen[filepath.Clean(skipped)] = true
+	}
+
+	var manifests []extraManifest
+
+	for _, configuredPath := range paths {
+		files, err := manifestFiles(filepath.Join(kubernetesDirectory, configuredPath))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+
+			seen[file] = true
+
+			objects, err := decodeManifestObjects(file)
+			if err != nil {
+				return nil, err
+			}
+
+			manifest := extraManifest{Path: file, Rank: len(manifestKindOrder)}
+
+			for _, object := range objects {
+				kind, _ := objectKindAndName(object)
+
+				manifest.Kinds = append(manifest.Kinds, kind)
+				manifest.Rank = min(manifest.Rank, manifestKindRank(kind))
+			}
+
+			manifests = append(manifests, manifest)
+		}
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Rank < manifests[j].Rank
+	})
+
+	return manifests, nil
+}
+
+// manifestFiles expands a configured path: a glob to its matches, a directory to its
+// manifest files, both sorted.
+func manifestFiles(manifestPath string) ([]string, error) {
+	manifestPath = filepath.Clean(manifestPath)
+
+	if strings.ContainsAny(manifestPath, "*?[") {
+		matches, err := filepath.Glob(manifestPath)
+		if err != nil {
+			return nil, &ConfigError{Reason: fmt.Sprintf("Invalid manifest glob '%s': %v", manifestPath, err)}
+		}
+
+		var files []string
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				files = append(files, match)
+			}
+		}
+
+		return files, nil
+	}
+
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		return nil, &ManifestMissingError{Path: manifestPath}
+	}
+
+	if !info.IsDir() {
+		return []string{manifestPath}, nil
+	}
+
+	entries, err := os.ReadDir(manifestPath)
+	if err != nil {
+		return nil, &ManifestError{Path: manifestPath, Reason: fmt.Sprintf("error reading manifest directory: %v", err)}
+	}
+
+	var files []string
+
+	for _, entry := range entries {
+		if !entry.IsDir() && manifestExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			files = append(files, filepath.Join(manifestPath, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// splitAroundWorkloads separates the manifests applied before the workload (configuration,
+// accounts, storage...) from those applied once it is healthy (autoscalers, ingresses...).
+func splitAroundWorkloads(manifests []extraManifest) ([]extraManifest, []extraManifest) {
+	workloadRank := manifestKindRank("Deployment")
+	split := sort.Search(len(manifests), func(i int) bool { return manifests[i].Rank >= workloadRank })
+
+	return manifests[:split], manifests[split:]
+}
+
+func manifestPaths(manifests []extraManifest) []string {
+	paths := make([]string, len(manifests))
+
+	for i, manifest := range manifests {
+		paths[i] = manifest.Path
+	}
+
+	return paths
+}
+
+// applyExtraManifests applies the manifests one file at a time, in order.
+func applyExtraManifests(env *types.EnvironmentConfig, cwd, label, serviceName string, manifests []extraManifest) error {
+	for _, manifest := range manifests {
+		fmt.Printf("[+] Applying %s: %s\n", strings.Join(manifest.Kinds, ", "), manifest.Path)
+
+		cmd := kubectlCommand(env, "apply", "-f", manifest.Path)
+		cmd.Dir = cwd
+		cmd.Label = label
+
+		_, errOutput, err := runCommand(cmd)
+
+		if err != nil {
+			return &DeployError{
+				ServiceName: serviceName,
+				Err:         fmt.Errorf("[!] Failed to apply %s for '%s': %s", manifest.Path, serviceName, commandError(err, errOutput)),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestBuildUpdatesTheWorkloadOfAMultiDocumentYaml(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: image-config\ndata:\n  image: keep-me:1.0.0\n---\n"
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+	writeTestFile(t, deploymentYamlPath, configMap+testDeploymentYaml)
+
+	buildInfo, err := Build(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if buildInfo.NewDockerImagePath != "udecrypt_image:1.0.2" {
+		t.Errorf("expected the version to come from the Deployment document, got %q", buildInfo.NewDockerImagePath)
+	}
+
+	expected := configMap + strings.Replace(testDeploymentYaml, "udecrypt_image:1.0.1", "udecrypt_image:1.0.2", 1)
+
+	if yaml := readTestFile(t, deploymentYamlPath); yaml != expected {
+		t.Errorf("unexpected deployment YAML:\n%s", yaml)
+	}
+}
+
+func TestDeployAppliesExtraManifestsInKindOrder(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: deploymentStateJson(1, 1, 1, 1)})
+
+	kubernetesDirectory := filepath.Join(cwd, "services", "go", "image", "k8s")
+
+	for name, kind := range map[string]string{
+		"extra/hpa.yaml":       "HorizontalPodAutoscaler",
+		"extra/monitor.yaml":   "ServiceMonitor",
+		"extra/pdb.yml":        "PodDisruptionBudget",
+		"extra/config.yaml":    "ConfigMap",
+		"extra/README.md":      "",
+		"ingress.preview.yaml": "Ingress",
+	} {
+		writeTestFile(t, filepath.Join(kubernetesDirectory, name), "kind: "+kind+"\nmetadata:\n  name: image\n")
+	}
+
+	cfg.Services = map[string]types.ServiceConfig{"image": {Manifests: types.ManifestConfig{
+		Paths:        []string{"extra"},
+		Environments: map[string][]string{"dev": {"ingress.preview.yaml"}, "prod": {"missing.yaml"}},
+	}}}
+
+	if err := DeployAlone(cfg, &types.Args{DeployTo: "dev", MicroserviceType: "go"}, cwd, "image"); err != nil {
+		t.Fatalf("DeployAlone returned an error: %v", err)
+	}
+
+	var applied []string
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl apply -f ") {
+			applied = append(applied, strings.TrimPrefix(strings.TrimPrefix(line, "kubectl apply -f "), kubernetesDirectory+"/"))
+		}
+	}
+
+	expected := []string{
+		"extra/pdb.yml",
+		"extra/config.yaml",
+		"service.yaml",
+		"deployment.dev.yaml",
+		"extra/hpa.yaml",
+		"ingress.preview.yaml",
+		"extra/monitor.yaml",
+	}
+
+	if strings.Join(applied, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected apply order:\n%s\nexpected:\n%s", strings.Join(applied, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Step results shown in the summary table
+const (
+	stepSucceeded = "ok"
+	stepFailed    = "failed"
+	stepSkipped   = "skipped"
+	stepCached    = "cached"     // the sources were unchanged, the previous image was reused
+	stepUpToDate  = "up-to-date" // the cluster already runs the image
+	stepNotRun    = "-"
+)
+
+// ServiceTarget is one service selected for a run.
+type ServiceTarget struct {
+	Name string
+	Type string
+}
+
+// ServiceResult is the outcome of building and/or deploying one service.
+type ServiceResult struct {
+	Target   ServiceTarget
+	Build    string
+	Deploy   string
+	Image    string
+	Duration time.Duration
+	Err      error
+}
+
+// ResolveServiceTargets turns the --svc, --type and --all flags into the list of services to
+// run. A service's type is looked up in ServicesDirectory.All when --type is not given.
+func ResolveServiceTargets(cfg *types.K8sDeployerConfig, serviceNames []string, serviceType string, all bool) ([]ServiceTarget, error) {
+	var targets []ServiceTarget
+
+	if all {
+		for configuredType, services := range cfg.ServicesDirectory.All {
+			if serviceType != "" && !strings.EqualFold(configuredType, serviceType) {
+				continue
+			}
+
+			for name := range services {
+				targets = append(targets, ServiceTarget{Name: name, Type: strings.ToLower(configuredType)})
+			}
+		}
+
+		sort.Slice(targets, func(i, j int) bool {
+			if targets[i].Type != targets[j].Type {
+				return targets[i].Type < targets[j].Type
+			}
+
+			return targets[i].Name < targets[j].Name
+		})
+
+		if len(targets) == 0 {
+			return nil, &UsageError{Reason: fmt.Sprintf("No services configured for type '%s'", serviceType)}
+		}
+
+		return targets, nil
+	}
+
+	seen := map[string]bool{}
+
+	for _, name := range serviceNames {
+		name = strings.TrimSpace(name)
+
+		if name == "" || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+
+		if serviceType != "" {
+			targets = append(targets, ServiceTarget{Name: name, Type: serviceType})
+			continue
+		}
+
+		serviceTypes := findServiceTypes(cfg, name)
+
+		switch len(serviceTypes) {
+		case 0:
+			return nil, &ServiceNotFoundError{ServiceName: name}
+		case 1:
+			targets = append(targets, ServiceTarget{Name: name, Type: serviceTypes[0]})
+		default:
+			return nil, &UsageError{Reason: fmt.Sprintf("Service %s is configured for several types (%s), pick one with --type", name, strings.Join(serviceTypes, ", "))}
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, &UsageError{Reason: "No service name provided."}
+	}
+
+	return targets, nil
+}
+
+// findServiceTypes returns the lower-cased service types that configure a service name.
+func findServiceTypes(cfg *types.K8sDeployerConfig, serviceName string) []string {
+	var serviceTypes []string
+
+	for configuredType, services := range cfg.ServicesDirectory.All {
+		if _, found := services[serviceName]; found {
+			serviceTypes = append(serviceTypes, strings.ToLower(configuredType))
+		}
+	}
+
+	sort.Strings(serviceTypes)
+
+	return serviceTypes
+}
+
+// orderByDependencies sorts the targets so every service comes after the services it
+// DependsOn. Dependencies that are not part of the run are assumed to be deployed already.
+func orderByDependencies(cfg *types.K8sDeployerConfig, targets []ServiceTarget) ([]ServiceTarget, error) {
+	index := map[string]int{}
+
+	for i, target := range targets {
+		index[target.Name] = i
+	}
+
+	dependents := make([][]int, len(targets))
+	pending := make([]int, len(targets))
+
+	for i, target := range targets {
+		for _, dependency := range cfg.Services[target.Name].DependsOn {
+			if len(findServiceTypes(cfg, dependency)) == 0 {
+				return nil, &ConfigError{Reason: fmt.Sprintf("%s depends on %s, which is not a configured service", target.Name, dependency)}
+			}
+
+			if j, selected := index[dependency]; selected {
+				dependents[j] = append(dependents[j], i)
+				pending[i]++
+			}
+		}
+	}
+
+	ordered := make([]ServiceTarget, 0, len(targets))
+	done := make([]bool, len(targets))
+
+	// Kahn's algorithm, always picking the earliest ready target to keep the order stable
+	for len(ordered) < len(targets) {
+		next := -1
+
+		for i := range targets {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			var cycle []string
+
+			for i, target := range targets {
+				if !done[i] {
+					cycle = append(cycle, target.Name)
+				}
+			}
+
+			return nil, &ConfigError{Reason: fmt.Sprintf("Circular DependsOn between: %s", strings.Join(cycle, ", "))}
+		}
+
+		done[next] = true
+		ordered = append(ordered, targets[next])
+
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+		}
+	}
+
+	return ordered, nil
+}
+
+// RunPipeline runs the operation ("build", "deploy", "bnd" or "rollback") for every target.
+// Builds run in parallel on up to jobs workers, deploys and rollbacks run one at a time in
+// dependency order and are skipped when the service's build or one of its dependencies failed.
+func RunPipeline(
+	cfg *types.K8sDeployerConfig,
+	args *types.Args,
+	cwd, operation string,
+	targets []ServiceTarget,
+	jobs int,
+) ([]*ServiceResult, error) {
+	ordered, err := orderByDependencies(cfg, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ServiceResult, len(ordered))
+	buildInfos := make([]*BuildInfo, len(ordered))
+	byName := map[string]*ServiceResult{}
+
+	for i, target := range ordered {
+		results[i] = &ServiceResult{Target: target, Build: stepNotRun, Deploy: stepNotRun}
+		byName[target.Name] = results[i]
+	}
+
+	if operation == "build" || operation == "bnd" {
+		if jobs < 1 {
+			jobs = 1
+		}
+
+		var wg sync.WaitGroup
+		queue := make(chan int)
+
+		for worker := 0; worker < jobs; worker++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for i := range queue {
+					result := results[i]
+					started := time.Now()
+
+					emitEvent(Event{Type: EventStepStarted, Service: result.Target.Name, ServiceType: result.Target.Type, Step: "build"})
+
+					buildInfos[i], result.Err = Build(cfg, targetArgs(args, result.Target), cwd, result.Target.Name)
+					result.Duration += time.Since(started)
+
+					if result.Err != nil {
+						result.Build = stepFailed
+					} else if buildInfos[i].Cached {
+						result.Build = stepCached
+						result.Image = buildInfos[i].NewDockerImagePath
+					} else {
+						result.Build = stepSucceeded
+						result.Image = buildInfos[i].NewDockerImagePath
+					}
+
+					emitEvent(stepFinishedEvent(result.Target, "build", result.Build, time.Since(started), result.Image, result.Err))
+				}
+			}()
+		}
+
+		for i := range ordered {
+			queue <- i
+		}
+
+		close(queue)
+		wg.Wait()
+	}
+
+	if operation == "deploy" || operation == "bnd" || operation == "rollback" {
+		for i, result := range results {
+			if result.Build == stepFailed || !dependenciesDeployed(cfg, result.Target, byName) {
+				result.Deploy = stepSkipped
+				emitEvent(stepFinishedEvent(result.Target, "deploy", result.Deploy, 0, "", nil))
+
+				continue
+			}
+
+			started := time.Now()
+
+			emitEvent(Event{Type: EventStepStarted, Service: result.Target.Name, ServiceType: result.Target.Type, Step: "deploy"})
+
+			if operation == "bnd" && buildInfos[i].Cached && !args.DryRun {
+				if upToDate, err := IsDeployed(cfg, targetArgs(args, result.Target), result.Target.Name, buildInfos[i].DeploymentYamlPath, buildInfos[i].NewDockerImagePath); err == nil && upToDate {
+					fmt.Printf("[+] '%s' already runs %s, nothing to deploy\n", result.Target.Name, buildInfos[i].NewDockerImagePath)
+
+					result.Deploy = stepUpToDate
+					result.Duration += time.Since(started)
+
+					emitEvent(stepFinishedEvent(result.Target, "deploy", result.Deploy, time.Since(started), result.Image, nil))
+
+					continue
+				}
+			}
+
+			switch operation {
+			case "bnd":
+				result.Err = DeployAfterBuild(cfg, targetArgs(args, result.Target), buildInfos[i], result.Target.Name)
+			case "rollback":
+				result.Image, result.Err = Rollback(cfg, targetArgs(args, result.Target), cwd, result.Target.Name)
+			default:
+				result.Err = DeployAlone(cfg, targetArgs(args, result.Target), cwd, result.Target.Name)
+			}
+
+			result.Duration += time.Since(started)
+
+			if result.Err != nil {
+				result.Deploy = stepFailed
+			} else {
+				result.Deploy = stepSucceeded
+			}
+
+			emitEvent(stepFinishedEvent(result.Target, "deploy", result.Deploy, time.Since(started), result.Image, result.Err))
+		}
+	}
+
+	return results, nil
+}
+
+func targetArgs(args *types.Args, target ServiceTarget) *types.Args {
+	serviceArgs := *args
+	serviceArgs.MicroserviceType = target.Type
+
+	return &serviceArgs
+}
+
+// dependenciesDeployed reports whether every dependency that is part of the run deployed.
+func dependenciesDeployed(cfg *types.K8sDeployerConfig, target ServiceTarget, byName map[string]*ServiceResult) bool {
+	for _, dependency := range cfg.Services[target.Name].DependsOn {
+		if result, selected := byName[dependency]; selected && result.Deploy != stepSucceeded && result.Deploy != stepUpToDate {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PipelineFailed reports whether any service of the run failed or was skipped.
+func PipelineFailed(results []*ServiceResult) bool {
+	for _, result := range results {
+		if result.Build == stepFailed || result.Build == stepSkipped ||
+			result.Deploy == stepFailed || result.Deploy == stepSkipped {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrintSummary writes a table with the per-service results of a run.
+func PrintSummary(w io.Writer, results []*ServiceResult) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "SERVICE\tTYPE\tBUILD\tDEPLOY\tIMAGE\tDURATION\tERROR")
+
+	for _, result := range results {
+		image, errMessage := result.Image, ""
+
+		if image == "" {
+			image = "-"
+		}
+
+		if result.Err != nil {
+			errMessage = strings.SplitN(strings.TrimSpace(result.Err.Error()), "\n", 2)[0]
+		}
+
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.Target.Name,
+			result.Target.Type,
+			result.Build,
+			result.Deploy,
+			image,
+			result.Duration.Round(time.Second),
+			errMessage,
+		)
+	}
+
+	table.Flush()
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func pipelineTestConfig(dependsOn map[string][]string) *types.K8sDeployerConfig {
+	cfg := &types.K8sDeployerConfig{
+		ServicesDirectory: types.ServicesDirectory{
+			All: types.AllServices{
+				"Go":   {"api": "api", "auth": "auth", "file": "file"},
+				"Node": {"web": "web", "auth": "auth-ui"},
+			},
+		},
+		Services: map[string]types.ServiceConfig{},
+	}
+
+	for name, dependencies := range dependsOn {
+		cfg.Services[name] = types.ServiceConfig{DependsOn: dependencies}
+	}
+
+	return cfg
+}
+
+func targetNames(targets []ServiceTarget) string {
+	names := make([]string, len(targets))
+
+	for i, target := range targets {
+		names[i] = target.Type + "/" + target.Name
+	}
+
+	return strings.Join(names, " ")
+}
+
+func TestResolveServiceTargets(t *testing.T) {
+	cfg := pipelineTestConfig(nil)
+
+	cases := []struct {
+		name        string
+		services    []string
+		serviceType string
+		all         bool
+		expected    string
+	}{
+		{"all", nil, "", true, "go/api go/auth go/file node/auth node/web"},
+		{"all of a type", nil, "node", true, "node/auth node/web"},
+		{"all of a type in another case", nil, "GO", true, "go/api go/auth go/file"},
+		{"names", []string{"web", " api", "web", ""}, "", false, "node/web go/api"},
+		{"names of a type", []string{"auth"}, "go", false, "go/auth"},
+	}
+
+	for _, c := range cases {
+		targets, err := ResolveServiceTargets(cfg, c.services, c.serviceType, c.all)
+
+		if err != nil || targetNames(targets) != c.expected {
+			t.Errorf("%s: got %q, %v, expected %q", c.name, targetNames(targets), err, c.expected)
+		}
+	}
+
+	var usageErr *UsageError
+	var notFoundErr *ServiceNotFoundError
+
+	if _, err := ResolveServiceTargets(cfg, nil, "rust", true); !errors.As(err, &usageErr) {
+		t.Errorf("expected a *UsageError for a type without services, got %v", err)
+	}
+
+	if _, err := ResolveServiceTargets(cfg, []string{"auth"}, "", false); !errors.As(err, &usageErr) || !strings.Contains(err.Error(), "go, node") {
+		t.Errorf("expected a *UsageError naming the types of an ambiguous service, got %v", err)
+	}
+
+	if _, err := ResolveServiceTargets(cfg, []string{"billing"}, "", false); !errors.As(err, &notFoundErr) {
+		t.Errorf("expected a *ServiceNotFoundError, got %v", err)
+	}
+
+	if _, err := ResolveServiceTargets(cfg, []string{" "}, "", false); !errors.As(err, &usageErr) {
+		t.Errorf("expected a *UsageError without a service name, got %v", err)
+	}
+}
+
+func TestOrderByDependencies(t *testing.T) {
+	targets := []ServiceTarget{{"api", "go"}, {"auth", "go"}, {"web", "node"}, {"file", "go"}}
+
+	cases := []struct {
+		name      string
+		dependsOn map[string][]string
+		expected  string
+	}{
+		{"no dependencies keep their order", nil, "go/api go/auth node/web go/file"},
+		{
+			"dependencies first",
+			map[string][]string{"api": {"auth", "file"}, "web": {"api"}},
+			"go/auth go/file go/api node/web",
+		},
+		{
+			"dependencies outside the run are ignored",
+			map[string][]string{"auth": {"api"}, "file": {"web"}},
+			"go/api go/auth node/web go/file",
+		},
+	}
+
+	for _, c := range cases {
+		ordered, err := orderByDependencies(pipelineTestConfig(c.dependsOn), targets)
+
+		if err != nil || targetNames(ordered) != c.expected {
+			t.Errorf("%s: got %q, %v, expected %q", c.name, targetNames(ordered), err, c.expected)
+		}
+	}
+
+	selected, err := orderByDependencies(pipelineTestConfig(map[string][]string{"api": {"file"}}), targets[:1])
+
+	if err != nil || targetNames(selected) != "go/api" {
+		t.Errorf("a dependency that is not selected should not be added, got %q, %v", targetNames(selected), err)
+	}
+
+	var configErr *ConfigError
+
+	_, err = orderByDependencies(pipelineTestConfig(map[string][]string{"api": {"auth"}, "auth": {"web"}, "web": {"api"}}), targets)
+
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), "Circular DependsOn between: api, auth, web") {
+		t.Errorf("expected a *ConfigError naming the cycle, got %v", err)
+	}
+
+	_, err = orderByDependencies(pipelineTestConfig(map[string][]string{"api": {"billing"}}), targets)
+
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), "billing") {
+		t.Errorf("expected a *ConfigError for an unknown dependency, got %v", err)
+	}
+}
+
+func TestRunPipelineSkipsDependentsOfFailedServices(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+
+	// A second service, "file", that "image" depends on
+	fileKubernetesDirectory := filepath.Join(cwd, "services", "go", "file", "k8s")
+	fileYaml := strings.NewReplacer("udecrypt-image-service", "udecrypt-file-service", "udecrypt_image", "udecrypt_file").Replace(testDeploymentYaml)
+
+	writeTestFile(t, filepath.Join(fileKubernetesDirectory, "deployment.dev.yaml"), fileYaml)
+	writeTestFile(t, filepath.Join(fileKubernetesDirectory, "service.yaml"), testServiceYaml)
+
+	cfg.ServicesDirectory.All["Go"]["file"] = "file"
+	cfg.Services = map[string]types.ServiceConfig{"image": {DependsOn: []string{"file"}}}
+
+	targets := []ServiceTarget{{"image", "go"}, {"file", "go"}}
+
+	cases := []struct {
+		operation, failing string
+		expected           []string // name, build and deploy of each result
+	}{
+		{"build", "go build -o " + filepath.Join(cwd, "services", "go", "file"), []string{"file failed -", "image ok -"}},
+		{"bnd", "go build -o " + filepath.Join(cwd, "services", "go", "file"), []string{"file failed skipped", "image ok skipped"}},
+		{"deploy", "kubectl apply -f " + filepath.Join(fileKubernetesDirectory, "deployment.dev.yaml"), []string{"file - failed", "image - skipped"}},
+	}
+
+	for _, c := range cases {
+		recorder.On(c.failing, RecordedOutput{Stderr: "boom", Err: errors.New("exit status 1")})
+
+		results, err := RunPipeline(cfg, &types.Args{DeployTo: "dev", Force: true}, cwd, c.operation, targets, 2)
+		if err != nil {
+			t.Fatalf("%s: RunPipeline returned an error: %v", c.operation, err)
+		}
+
+		var summary []string
+
+		for _, result := range results {
+			summary = append(summary, result.Target.Name+" "+result.Build+" "+result.Deploy)
+		}
+
+		if !reflect.DeepEqual(summary, c.expected) {
+			t.Errorf("%s: got %q, expected %q", c.operation, summary, c.expected)
+		}
+
+		if !PipelineFailed(results) {
+			t.Errorf("%s: expected the run to be reported as failed", c.operation)
+		}
+
+		recorder.On(c.failing, RecordedOutput{})
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl apply -f "+filepath.Join(cwd, "services", "go", "image")) {
+			t.Errorf("image was deployed although file was not: %s", line)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Platform is a Docker target platform such as "linux/arm64" or "linux/arm/v7".
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// ParsePlatform parses an `os/arch[/variant]` platform.
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, &ConfigError{Reason: fmt.Sprintf("Invalid platform '%s' (expected os/arch or os/arch/variant)", platform)}
+	}
+
+	parsed := Platform{OS: parts[0], Arch: parts[1]}
+
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+
+	return parsed, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Arch
+	}
+
+	return p.OS + "/" + p.Arch + "/" + p.Variant
+}
+
+// DirectoryName is the subdirectory of the build output a platform's artifacts go to, the
+// same as `${TARGETOS}_${TARGETARCH}` (plus `_${TARGETVARIANT}`) in a Dockerfile.
+func (p Platform) DirectoryName() string {
+	return strings.ReplaceAll(p.String(), "/", "_")
+}
+
+// selectPlatforms returns the platforms the image of a service is built for. Images pushed
+// to a registry are built for every configured platform; images loaded straight into a
+// local cluster can only hold one, so the one matching the cluster's nodes is picked.
+// Services without Platforms return nil and are built the single-platform way.
+func selectPlatforms(env *types.EnvironmentConfig, cwd string, serviceConfig types.ServiceConfig) ([]Platform, error) {
+	if len(serviceConfig.Platforms) == 0 {
+		return nil, nil
+	}
+
+	platforms := make([]Platform, 0, len(serviceConfig.Platforms))
+
+	for _, platform := range serviceConfig.Platforms {
+		parsed, err := ParsePlatform(platform)
+		if err != nil {
+			return nil, err
+		}
+
+		platforms = append(platforms, parsed)
+	}
+
+	if env.ImageDelivery == constants.RegistryDelivery {
+		return platforms, nil
+	}
+
+	nodePlatform, err := clusterNodePlatform(env, cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, platform := range platforms {
+		if platform.OS == nodePlatform.OS && platform.Arch == nodePlatform.Arch {
+			fmt.Printf("[+] Building %s to match the cluster's nodes\n", platform)
+
+			return []Platform{platform}, nil
+		}
+	}
+
+	return nil, &ConfigError{Reason: fmt.Sprintf(
+		"None of the configured platforms (%s) matches the cluster's nodes (%s)",
+		strings.Join(serviceConfig.Platforms, ", "),
+		nodePlatform,
+	)}
+}
+
+// clusterNodePlatform reads the operating system and architecture of the first node.
+func clusterNodePlatform(env *types.EnvironmentConfig, cwd string) (Platform, error) {
+	cmd := kubectlCommand(env, "get", "nodes", "-o", "jsonpath={.items[0].status.nodeInfo.operatingSystem}/{.items[0].status.nodeInfo.architecture}")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return Platform{}, &ClusterError{Environment: env.Name, Reason: "failed to read the platform of the cluster's nodes: " + commandError(err, errOutput)}
+	}
+
+	platform, err := ParsePlatform(strings.TrimSpace(output))
+	if err != nil {
+		return Platform{}, &ClusterError{Environment: env.Name, Reason: fmt.Sprintf("unexpected node platform '%s'", strings.TrimSpace(output))}
+	}
+
+	return platform, nil
+}
+
+// pushedByBuildx tells whether the image is pushed while it is built. A multi-platform
+// image only exists as a manifest list in the registry, so buildx pushes it right away and
+// the deploy step must not push it again.
+func pushedByBuildx(env *types.EnvironmentConfig, serviceConfig types.ServiceConfig) bool {
+	return len(serviceConfig.Platforms) > 0 && env.ImageDelivery == constants.RegistryDelivery
+}
+
+func platformList(platforms []Platform) string {
+	names := make([]string, len(platforms))
+
+	for i, platform := range platforms {
+		names[i] = platform.String()
+	}
+
+	return strings.Join(names, ",")
+}
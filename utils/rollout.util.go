@@ -0,0 +1,504 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const defaultRolloutTimeout = "5m"
+
+// How often the deployment and its pods are polled while waiting for a rollout
+var rolloutPollInterval = 2 * time.Second
+
+// Container waiting reasons that will not resolve by waiting longer
+var fatalWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// The pod template containers of a workload
+type podTemplateState struct {
+	Spec struct {
+		Containers []struct {
+			Image string `json:"image"`
+		} `json:"containers"`
+	} `json:"spec"`
+}
+
+// The parts of `kubectl get <kind> -o json` the rollout watcher and status look at, for
+// every workload kind
+type workloadState struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas    *int64 `json:"replicas"`
+		Completions *int64 `json:"completions"`
+		Selector    struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"selector"`
+		Template       podTemplateState `json:"template"`
+		UpdateStrategy struct {
+			Type          string `json:"type"`
+			RollingUpdate *struct {
+				Partition *int64 `json:"partition"`
+			} `json:"rollingUpdate"`
+		} `json:"updateStrategy"`
+		JobTemplate struct {
+			Spec struct {
+				Template podTemplateState `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration  int64 `json:"observedGeneration"`
+		Replicas            int64 `json:"replicas"`
+		UpdatedReplicas     int64 `json:"updatedReplicas"`
+		ReadyReplicas       int64 `json:"readyReplicas"`
+		AvailableReplicas   int64 `json:"availableReplicas"`
+		UnavailableReplicas int64 `json:"unavailableReplicas"`
+
+		// StatefulSet
+		CurrentRevision string `json:"currentRevision"`
+		UpdateRevision  string `json:"updateRevision"`
+
+		// DaemonSet
+		DesiredNumberScheduled int64 `json:"desiredNumberScheduled"`
+		UpdatedNumberScheduled int64 `json:"updatedNumberScheduled"`
+		NumberReady            int64 `json:"numberReady"`
+		NumberAvailable        int64 `json:"numberAvailable"`
+
+		// Job and CronJob
+		Succeeded        int64      `json:"succeeded"`
+		Failed           int64      `json:"failed"`
+		StartTime        *time.Time `json:"startTime"`
+		CompletionTime   *time.Time `json:"completionTime"`
+		LastScheduleTime *time.Time `json:"lastScheduleTime"`
+
+		Conditions []struct {
+			Type           string    `json:"type"`
+			Status         string    `json:"status"`
+			Reason         string    `json:"reason"`
+			Message        string    `json:"message"`
+			LastUpdateTime time.Time `json:"lastUpdateTime"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// desiredReplicas is the replica count of the spec, which defaults to 1.
+func (s *workloadState) desiredReplicas() int64 {
+	if s.Spec.Replicas != nil {
+		return *s.Spec.Replicas
+	}
+
+	return 1
+}
+
+// image is the image of the first container of the workload's pod template.
+func (s *workloadState) image(kind string) string {
+	containers := s.Spec.Template.Spec.Containers
+
+	if kind == cronJobKind {
+		containers = s.Spec.JobTemplate.Spec.Template.Spec.Containers
+	}
+
+	if len(containers) == 0 {
+		return ""
+	}
+
+	return containers[0].Image
+}
+
+// readiness returns how many pods are ready (completions succeeded for a Job) out of how
+// many are wanted. A CronJob has no pods of its own and reports none.
+func (s *workloadState) readiness(kind string) (int64, int64) {
+	switch kind {
+	case daemonSetKind:
+		return s.Status.NumberReady, s.Status.DesiredNumberScheduled
+	case jobKind:
+		return s.Status.Succeeded, s.completions()
+	case cronJobKind:
+		return 0, 0
+	default:
+		return s.Status.ReadyReplicas, s.desiredReplicas()
+	}
+}
+
+// completions is the number of successful pods a Job needs, which defaults to 1.
+func (s *workloadState) completions() int64 {
+	if s.Spec.Completions != nil {
+		return *s.Spec.Completions
+	}
+
+	return 1
+}
+
+// lastRollout is the last time the workload's rollout progressed, or its job ran, and the
+// zero time when the kind does not report it.
+func (s *workloadState) lastRollout(kind string) time.Time {
+	var last time.Time
+
+	switch kind {
+	case deploymentKind:
+		for _, condition := range s.Status.Conditions {
+			if condition.Type == "Progressing" {
+				last = condition.LastUpdateTime
+			}
+		}
+	case jobKind:
+		if s.Status.CompletionTime != nil {
+			last = *s.Status.CompletionTime
+		} else if s.Status.StartTime != nil {
+			last = *s.Status.StartTime
+		}
+	case cronJobKind:
+		if s.Status.LastScheduleTime != nil {
+			last = *s.Status.LastScheduleTime
+		}
+	}
+
+	return last
+}
+
+// partition returns the ordinal below which a StatefulSet rolling update leaves pods alone.
+func (s *workloadState) partition() int64 {
+	if rollingUpdate := s.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		return *rollingUpdate.Partition
+	}
+
+	return 0
+}
+
+// rolloutProgress describes how far the rollout of the workload got, whether it is done,
+// and the error of a rollout that will not get any further.
+func (s *workloadState) rolloutProgress(kind string) (string, bool, error) {
+	observed := s.Status.ObservedGeneration >= s.Metadata.Generation
+
+	// With OnDelete the controller only replaces pods someone deletes, so there is no
+	// rollout to wait for once the new spec is observed
+	if (kind == statefulSetKind || kind == daemonSetKind) && s.Spec.UpdateStrategy.Type == "OnDelete" {
+		return "OnDelete update strategy, pods pick up the new spec when they are deleted", observed, nil
+	}
+
+	switch kind {
+	case statefulSetKind:
+		desired := s.desiredReplicas()
+		progress := fmt.Sprintf("%d/%d updated, %d/%d ready", s.Status.UpdatedReplicas, desired, s.Status.ReadyReplicas, desired)
+
+		// A partitioned rolling update only replaces the pods with an ordinal at or above
+		// the partition, so the current revision never becomes the update revision
+		if partition := s.partition(); partition > 0 {
+			return progress, observed &&
+				s.Status.UpdatedReplicas >= max(desired-partition, 0) &&
+				s.Status.ReadyReplicas == desired, nil
+		}
+
+		return progress, observed &&
+			s.Status.UpdatedReplicas == desired &&
+			s.Status.Replicas == desired &&
+			s.Status.ReadyReplicas == desired &&
+			s.Status.CurrentRevision == s.Status.UpdateRevision, nil
+
+	case daemonSetKind:
+		desired := s.Status.DesiredNumberScheduled
+		progress := fmt.Sprintf("%d/%d updated, %d/%d available", s.Status.UpdatedNumberScheduled, desired, s.Status.NumberAvailable, desired)
+
+		return progress, observed &&
+			s.Status.UpdatedNumberScheduled == desired &&
+			s.Status.NumberAvailable == desired, nil
+
+	case jobKind:
+		completions := s.completions()
+		progress := fmt.Sprintf("%d/%d succeeded, %d failed", s.Status.Succeeded, completions, s.Status.Failed)
+
+		for _, condition := range s.Status.Conditions {
+			if condition.Type == "Failed" && condition.Status == "True" {
+				return progress, false, fmt.Errorf("failed (%s): %s", condition.Reason, condition.Message)
+			}
+		}
+
+		return progress, s.Status.Succeeded >= completions, nil
+
+	default:
+		desired := s.desiredReplicas()
+		progress := fmt.Sprintf("%d/%d updated, %d/%d available", s.Status.UpdatedReplicas, desired, s.Status.AvailableReplicas, desired)
+
+		for _, condition := range s.Status.Conditions {
+			if condition.Type == "Progressing" && condition.Reason == "ProgressDeadlineExceeded" {
+				return progress, false, fmt.Errorf("exceeded its progress deadline: %s", condition.Message)
+			}
+		}
+
+		return progress, observed &&
+			s.Status.UpdatedReplicas == desired &&
+			s.Status.Replicas == desired &&
+			s.Status.AvailableReplicas == desired &&
+			s.Status.UnavailableReplicas == 0, nil
+	}
+}
+
+// The parts of `kubectl get pods -o json` the rollout watcher and status look at
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Image string `json:"image"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			ContainerStatuses []struct {
+				Name         string `json:"name"`
+				RestartCount int64  `json:"restartCount"`
+				State        struct {
+					Waiting *struct {
+						Reason  string `json:"reason"`
+						Message string `json:"message"`
+					} `json:"waiting"`
+				} `json:"state"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// waitForRollout polls the workload until every pod runs the new pod template and is
+// available, or until a Job completes. It gives up early when a pod running dockerImagePath
+// is stuck in a state that will not recover on its own, and when the timeout is reached.
+// A CronJob only starts pods on its schedule, so there is nothing to wait for.
+func waitForRollout(env *types.EnvironmentConfig, cwd string, workload *Workload, dockerImagePath, timeout string) error {
+	name := workload.Name
+
+	if workload.Kind == cronJobKind {
+		fmt.Printf("[+] '%s' is a CronJob, its next scheduled job will run %s\n", name, dockerImagePath)
+
+		return nil
+	}
+
+	fmt.Printf("[+] Waiting up to %s for the rollout of '%s' to become healthy...\n", timeout, name)
+
+	timeoutDuration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("[!] Invalid rollout timeout '%s': %v", timeout, err)
+	}
+
+	deadline := time.Now().Add(timeoutDuration)
+	lastProgress := ""
+
+	for {
+		state, err := getWorkloadState(env, cwd, workload)
+		if err != nil {
+			return err
+		}
+
+		progress, done, err := state.rolloutProgress(workload.Kind)
+
+		if progress != lastProgress {
+			fmt.Printf("[->] %s\n", progress)
+			lastProgress = progress
+		}
+
+		if done {
+			fmt.Printf("[+] Rollout of '%s' is healthy\n", name)
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("[!] Rollout of '%s' %v", name, err)
+		}
+
+		if err := checkPodsForFailures(env, cwd, state.Spec.Selector.MatchLabels, dockerImagePath); err != nil {
+			return fmt.Errorf("[!] Rollout of '%s' failed: %v", name, err)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("[!] Rollout of '%s' did not become healthy within %s (%s)", name, timeout, progress)
+		}
+
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+func getWorkloadState(env *types.EnvironmentConfig, cwd string, workload *Workload) (*workloadState, error) {
+	cmd := kubectlCommand(env, "get", workload.resourceType(), workload.Name, "-o", "json")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		return nil, fmt.Errorf("[!] Failed to get the state of %s '%s': %s", workload.resourceType(), workload.Name, commandError(err, errOutput))
+	}
+
+	var state workloadState
+	if err := json.Unmarshal([]byte(output), &state); err != nil {
+		return nil, fmt.Errorf("[!] Failed to parse the state of %s '%s': %v", workload.resourceType(), workload.Name, err)
+	}
+
+	return &state, nil
+}
+
+// checkPodsForFailures returns an error describing the first pod running dockerImagePath
+// whose containers are waiting for a reason listed in fatalWaitingReasons.
+func checkPodsForFailures(env *types.EnvironmentConfig, cwd string, matchLabels map[string]string, dockerImagePath string) error {
+	if len(matchLabels) == 0 {
+		return nil
+	}
+
+	cmd := kubectlCommand(env, "get", "pods", "-l", labelSelector(matchLabels), "-o", "json")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, _, err := runCommand(cmd)
+
+	// Pod listing is best effort, the deployment status alone still decides success
+	if err != nil {
+		return nil
+	}
+
+	var pods podList
+	if err := json.Unmarshal([]byte(output), &pods); err != nil {
+		return nil
+	}
+
+	for _, pod := range pods.Items {
+		runsNewImage := false
+
+		for _, container := range pod.Spec.Containers {
+			if container.Image == dockerImagePath {
+				runsNewImage = true
+			}
+		}
+
+		if !runsNewImage {
+			continue
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil && fatalWaitingReasons[status.State.Waiting.Reason] {
+				return fmt.Errorf(
+					"pod %s container %s is in %s: %s",
+					pod.Metadata.Name,
+					status.Name,
+					status.State.Waiting.Reason,
+					status.State.Waiting.Message,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getLiveImage returns the image of the first container of the workload running in the
+// cluster, or an empty string when the workload does not exist yet.
+func getLiveImage(env *types.EnvironmentConfig, cwd string, workload *Workload) string {
+	cmd := kubectlCommand(
+		env, "get", workload.resourceType(), workload.Name,
+		"-o", "jsonpath={."+strings.Join(podTemplateSpecPath(workload.Kind), ".")+".containers[0].image}",
+		"--ignore-not-found",
+	)
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, _, err := runCommand(cmd)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(output)
+}
+
+// rollbackWorkload puts the previous image back in the cluster and in the deployment YAML
+// after a failed rollout, and returns the image it restored. A rolling update is undone
+// through the workload's own revision history, which can hold another image than the one
+// running before this deploy, so the YAML gets whatever image the undo left live. When
+// there is no history (recreate strategy) the restored YAML is applied again.
+func rollbackWorkload(env *types.EnvironmentConfig, cwd, label string, workload *Workload, undo bool, deploymentFilePath, failedImage, previousImage string) (string, error) {
+	name := workload.Name
+
+	if previousImage == "" {
+		return "", fmt.Errorf("[!] No previous image of '%s' is known, nothing to roll back to", name)
+	}
+
+	if previousImage == failedImage {
+		return "", fmt.Errorf("[!] '%s' was already running %s before this deploy, nothing to roll back to", name, failedImage)
+	}
+
+	if !undo {
+		fmt.Printf("[!] Rolling '%s' back to %s...\n", name, previousImage)
+
+		if err := restoreYamlImage(deploymentFilePath, previousImage); err != nil {
+			return "", err
+		}
+
+		cmd := kubectlCommand(env, "apply", "-f", deploymentFilePath)
+		cmd.Dir = cwd
+		cmd.Label = label
+
+		if _, errOutput, err := runCommand(cmd); err != nil {
+			return "", fmt.Errorf("[!] Failed to roll back '%s': %s", name, commandError(err, errOutput))
+		}
+
+		return previousImage, nil
+	}
+
+	fmt.Printf("[!] Rolling '%s' back to its previous revision...\n", name)
+
+	cmd := kubectlCommand(env, "rollout", "undo", workload.Resource())
+	cmd.Dir = cwd
+	cmd.Label = label
+
+	if _, errOutput, err := runCommand(cmd); err != nil {
+		return "", fmt.Errorf("[!] Failed to roll back '%s': %s", name, commandError(err, errOutput))
+	}
+
+	restoredImage := getLiveImage(env, cwd, workload)
+
+	if restoredImage == "" {
+		restoredImage = previousImage
+	} else if restoredImage != previousImage {
+		fmt.Printf("[!] The previous revision of '%s' runs %s, not %s which was live before this deploy\n", name, restoredImage, previousImage)
+	}
+
+	if err := restoreYamlImage(deploymentFilePath, restoredImage); err != nil {
+		return "", err
+	}
+
+	return restoredImage, nil
+}
+
+func restoreYamlImage(deploymentFilePath, image string) error {
+	fmt.Printf("[+] Restoring the image %s in deployment YAML file: %s\n", image, deploymentFilePath)
+
+	if err := UpdateYaml(deploymentFilePath, image); err != nil {
+		return fmt.Errorf("[!] Failed to restore the deployment YAML file: %v", err)
+	}
+
+	return nil
+}
+
+// labelSelector turns matchLabels into a `-l` selector with a stable order.
+func labelSelector(matchLabels map[string]string) string {
+	selectors := make([]string, 0, len(matchLabels))
+
+	for key, value := range matchLabels {
+		selectors = append(selectors, key+"="+value)
+	}
+
+	sort.Strings(selectors)
+
+	return strings.Join(selectors, ",")
+}
@@ -0,0 +1,335 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is one invocation of an external program (go, dotnet, docker, kubectl, ...).
+type Command struct {
+	Name string
+	Args []string
+	Dir  string   // working directory, the current one when empty
+	Env  []string // KEY=value pairs added to the inherited environment
+
+	// Label names the service the command runs for, e.g. "go/api" (see serviceLabel), so
+	// the streamed output of services built in parallel can be told apart
+	Label string
+
+	// Quiet commands only query state (git, kubectl get, ...), their output is returned but
+	// not streamed. A dry run still runs them, so they must never change anything.
+	Quiet bool
+}
+
+func (c Command) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// CommandRunner runs external commands for the build and deploy pipeline. The default
+// ExecRunner starts real processes; a RecordingRunner can be swapped in with
+// SetCommandRunner to test the pipeline without any toolchain or cluster.
+type CommandRunner interface {
+	// Run executes the command and returns its standard output and standard error.
+	Run(cmd Command) (string, string, error)
+}
+
+// ExecRunner runs commands as child processes through os/exec. Unless a command is Quiet,
+// a header is printed before it runs and its standard output and standard error are
+// streamed line by line as they are written. The header, every line and the footer carry
+// the command's Label, or the directory it runs in without one, so the output of services
+// built in parallel stays apart.
+type ExecRunner struct {
+	Out io.Writer // where streamed output goes, os.Stdout when nil
+}
+
+// Streamed lines of concurrent commands must not interleave mid-line
+var streamMutex sync.Mutex
+
+func (r ExecRunner) Run(command Command) (string, string, error) {
+	cmd := exec.Command(command.Name, command.Args...)
+	cmd.Dir = command.Dir
+
+	if len(command.Env) > 0 {
+		cmd.Env = append(os.Environ(), command.Env...)
+	}
+
+	var output, errOutput bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &errOutput
+
+	if command.Quiet {
+		err := cmd.Run()
+
+		return output.String(), errOutput.String(), err
+	}
+
+	out := r.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	label := command.Name
+
+	switch {
+	case command.Label != "":
+		label = command.Label + " " + command.Name
+	case command.Dir != "":
+		label = filepath.Base(command.Dir) + "/" + command.Name
+	}
+
+	streamMutex.Lock()
+
+	if command.Label != "" {
+		fmt.Fprintf(out, "[$] %s: %s\n", command.Label, command.String())
+	} else {
+		fmt.Fprintf(out, "[$] %s\n", command.String())
+	}
+
+	if command.Dir != "" {
+		fmt.Fprintf(out, "    in %s\n", command.Dir)
+	}
+
+	streamMutex.Unlock()
+
+	stdout := &lineWriter{out: out, prefix: "    " + label + " | "}
+	stderr := &lineWriter{out: out, prefix: "    " + label + " ! "}
+
+	cmd.Stdout = io.MultiWriter(&output, stdout)
+	cmd.Stderr = io.MultiWriter(&errOutput, stderr)
+
+	started := time.Now()
+	err := cmd.Run()
+
+	stdout.Flush()
+	stderr.Flush()
+
+	status := "done"
+
+	if err != nil {
+		status = "failed: " + err.Error()
+	}
+
+	streamMutex.Lock()
+	fmt.Fprintf(out, "[$] %s %s in %s\n", label, status, time.Since(started).Round(time.Millisecond))
+	streamMutex.Unlock()
+
+	return output.String(), errOutput.String(), err
+}
+
+// lineWriter writes complete lines to out, each with a prefix. Progress output that redraws
+// its line with carriage returns, like docker push, is written once as its last redraw.
+type lineWriter struct {
+	out     io.Writer
+	prefix  string
+	pending []byte
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+
+	for {
+		newline := bytes.IndexByte(w.pending, '\n')
+		if newline < 0 {
+			break
+		}
+
+		w.writeLine(w.pending[:newline])
+		w.pending = w.pending[newline+1:]
+	}
+
+	return len(data), nil
+}
+
+// Flush writes the last line when the command did not end it with a newline.
+func (w *lineWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.writeLine(w.pending)
+		w.pending = nil
+	}
+}
+
+func (w *lineWriter) writeLine(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+
+	if redraw := bytes.LastIndexByte(line, '\r'); redraw >= 0 {
+		line = line[redraw+1:]
+	}
+
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+
+	streamMutex.Lock()
+	defer streamMutex.Unlock()
+
+	fmt.Fprintf(w.out, "%s%s\n", w.prefix, line)
+}
+
+var (
+	runnerMutex sync.RWMutex
+	runner      CommandRunner = ExecRunner{}
+)
+
+// SetCommandRunner replaces the runner used for every external command and returns the
+// previous one, so callers can restore it.
+func SetCommandRunner(commandRunner CommandRunner) CommandRunner {
+	runnerMutex.Lock()
+	defer runnerMutex.Unlock()
+
+	previous := runner
+	runner = commandRunner
+
+	return previous
+}
+
+func runCommand(cmd Command) (string, string, error) {
+	runnerMutex.RLock()
+	commandRunner := runner
+	runnerMutex.RUnlock()
+
+	return commandRunner.Run(cmd)
+}
+
+// RecordingRunner records the commands it is asked to run instead of running them, and
+// answers with the responses registered through On. It is safe for concurrent use.
+type RecordingRunner struct {
+	mutex     sync.Mutex
+	commands  []Command
+	responses []*recordedResponse
+}
+
+type recordedResponse struct {
+	prefix  string
+	outputs []RecordedOutput
+}
+
+// RecordedOutput is what a RecordingRunner returns for a matching command.
+type RecordedOutput struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// On registers the outputs returned for commands whose command line (see Command.String)
+// starts with prefix. The outputs are handed out in order and the last one is repeated.
+// Later registrations win over earlier ones; unmatched commands succeed with no output.
+func (r *RecordingRunner) On(prefix string, outputs ...RecordedOutput) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(outputs) == 0 {
+		outputs = []RecordedOutput{{}}
+	}
+
+	r.responses = append(r.responses, &recordedResponse{prefix: prefix, outputs: outputs})
+}
+
+func (r *RecordingRunner) Run(cmd Command) (string, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.commands = append(r.commands, cmd)
+	commandLine := cmd.String()
+
+	for i := len(r.responses) - 1; i >= 0; i-- {
+		response := r.responses[i]
+
+		if !strings.HasPrefix(commandLine, response.prefix) {
+			continue
+		}
+
+		output := response.outputs[0]
+
+		if len(response.outputs) > 1 {
+			response.outputs = response.outputs[1:]
+		}
+
+		return output.Stdout, output.Stderr, output.Err
+	}
+
+	return "", "", nil
+}
+
+// Commands returns the commands recorded so far.
+func (r *RecordingRunner) Commands() []Command {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Command{}, r.commands...)
+}
+
+// CommandLines returns the recorded commands formatted with Command.String.
+func (r *RecordingRunner) CommandLines() []string {
+	commands := r.Commands()
+	lines := make([]string, len(commands))
+
+	for i, cmd := range commands {
+		lines[i] = cmd.String()
+	}
+
+	return lines
+}
+
+// DryRunRunner prints the commands that would change something instead of running them,
+// and reports every one of them as successful with no output. Quiet commands only read
+// state, so they are run for real and the plan is built from the actual versions, images
+// and cluster.
+type DryRunRunner struct {
+	Out     io.Writer
+	Queries CommandRunner // runs the Quiet commands, an ExecRunner when nil
+
+	mutex sync.Mutex
+}
+
+func (r *DryRunRunner) Run(cmd Command) (string, string, error) {
+	if cmd.Quiet {
+		if r.Queries == nil {
+			return ExecRunner{}.Run(cmd)
+		}
+
+		return r.Queries.Run(cmd)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fmt.Fprintf(r.Out, "[dry-run] $ %s\n", cmd.String())
+
+	if cmd.Dir != "" {
+		fmt.Fprintf(r.Out, "[dry-run]     in:  %s\n", cmd.Dir)
+	}
+
+	if len(cmd.Env) > 0 {
+		fmt.Fprintf(r.Out, "[dry-run]     env: %s\n", strings.Join(cmd.Env, " "))
+	}
+
+	return "", "", nil
+}
+
+// serviceLabel is the Label of the commands run for a service, its type and name the way
+// the run summary shows them, e.g. "go/api".
+func serviceLabel(serviceType, serviceName string) string {
+	if serviceType == "" {
+		return serviceName
+	}
+
+	return strings.ToLower(serviceType) + "/" + serviceName
+}
+
+// commandError formats a failed command's error with its standard error output.
+func commandError(err error, errOutput string) string {
+	errOutput = strings.TrimSpace(errOutput)
+
+	if errOutput == "" {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("%v: %s", err, errOutput)
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExecRunnerStreamsPrefixedLines(t *testing.T) {
+	var streamed strings.Builder
+	dir := t.TempDir()
+
+	stdout, stderr, err := ExecRunner{Out: &streamed}.Run(Command{
+		Name: "sh",
+		Args: []string{"-c", "echo built; echo warning >&2; printf partial"},
+		Dir:  dir,
+	})
+
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	if stdout != "built\npartial" || stderr != "warning\n" {
+		t.Errorf("unexpected captured output %q / %q", stdout, stderr)
+	}
+
+	label := dir[strings.LastIndex(dir, "/")+1:] + "/sh"
+
+	for _, line := range []string{
+		"[$] sh -c echo built; echo warning >&2; printf partial",
+		"    " + label + " | built",
+		"    " + label + " ! warning",
+		"    " + label + " | partial",
+		"[$] " + label + " done in ",
+	} {
+		if !strings.Contains(streamed.String(), line) {
+			t.Errorf("expected %q in the streamed output:\n%s", line, streamed.String())
+		}
+	}
+}
+
+func TestExecRunnerLabelsEveryLineWithTheService(t *testing.T) {
+	var streamed strings.Builder
+
+	_, _, err := ExecRunner{Out: &streamed}.Run(Command{
+		Name:  "sh",
+		Args:  []string{"-c", "echo built; echo warning >&2"},
+		Dir:   t.TempDir(),
+		Label: serviceLabel("Go", "api"),
+	})
+
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	expected := []string{
+		"[$] go/api: sh -c echo built; echo warning >&2",
+		"    go/api sh | built",
+		"    go/api sh ! warning",
+		"[$] go/api sh done in ",
+	}
+
+	lines := strings.Split(strings.TrimSuffix(streamed.String(), "\n"), "\n")
+
+	if len(lines) != 5 || !strings.HasPrefix(lines[4], expected[3]) {
+		t.Fatalf("unexpected streamed output:\n%s", streamed.String())
+	}
+
+	// The directory line is the only one without the label
+	for _, line := range append(lines[:1:1], lines[2:]...) {
+		if !strings.Contains(line, "go/api") {
+			t.Errorf("line without the service label: %q", line)
+		}
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(streamed.String(), line) {
+			t.Errorf("expected %q in the streamed output:\n%s", line, streamed.String())
+		}
+	}
+}
+
+func TestExecRunnerCollapsesCarriageReturnRedraws(t *testing.T) {
+	var streamed strings.Builder
+
+	_, _, err := ExecRunner{Out: &streamed}.Run(Command{
+		Name: "sh",
+		Args: []string{"-c", `printf 'layer: 10%%\rlayer: 60%%\rlayer: 100%%\r\npushed\r\n'`},
+	})
+
+	if err != nil {
+		t.Fatalf("Run returned an error: %v", err)
+	}
+
+	var lines []string
+
+	for _, line := range strings.Split(streamed.String(), "\n") {
+		if strings.Contains(line, " | ") {
+			lines = append(lines, line[strings.Index(line, " | ")+3:])
+		}
+	}
+
+	if strings.Join(lines, "\n") != "layer: 100%\npushed" {
+		t.Errorf("expected each redrawn line once, as its last redraw, got:\n%s", streamed.String())
+	}
+}
+
+func TestExecRunnerKeepsQuietCommandsQuiet(t *testing.T) {
+	var streamed strings.Builder
+
+	stdout, _, err := ExecRunner{Out: &streamed}.Run(Command{Name: "sh", Args: []string{"-c", "echo state"}, Quiet: true})
+
+	if err != nil || stdout != "state\n" {
+		t.Fatalf("unexpected result %q, %v", stdout, err)
+	}
+
+	if streamed.Len() != 0 {
+		t.Errorf("a quiet command was streamed:\n%s", streamed.String())
+	}
+}
+
+func TestRunLogCapturesTheTranscript(t *testing.T) {
+	cwd := t.TempDir()
+
+	runLog, err := StartRunLog(cwd)
+	if err != nil {
+		t.Fatalf("StartRunLog returned an error: %v", err)
+	}
+
+	fmt.Println("[+] Build process started...")
+	ExecRunner{}.Run(Command{Name: "sh", Args: []string{"-c", "echo compiling; echo oops >&2"}})
+
+	if err := runLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(runLog.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"[+] Build process started...", "sh | compiling", "sh ! oops"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("expected %q in the run log:\n%s", line, data)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	stateDirectory = ".k8s-deployer"
+	stateFileName  = "state.json"
+)
+
+// BuildState is the local record of the last image built for every service and
+// environment, kept in .k8s-deployer/state.json next to the config file.
+type BuildState struct {
+	Services map[string]ServiceBuildState `json:"Services"` // keyed by stateKey
+}
+
+// ServiceBuildState is the last image built for one service in one environment.
+type ServiceBuildState struct {
+	SourceHash string    `json:"SourceHash"`
+	Image      string    `json:"Image"`
+	Version    string    `json:"Version"`
+	BuiltAt    time.Time `json:"BuiltAt"`
+}
+
+// Builds run in parallel, so every read-modify-write of the state file is serialized
+var stateMutex sync.Mutex
+
+func stateFilePath(cwd string) string {
+	return filepath.Join(cwd, stateDirectory, stateFileName)
+}
+
+func stateKey(environment, serviceName string) string {
+	return environment + "/" + serviceName
+}
+
+// loadBuildState reads the state file, returning an empty state when there is none yet.
+func loadBuildState(cwd string) (*BuildState, error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	return readBuildState(cwd)
+}
+
+func readBuildState(cwd string) (*BuildState, error) {
+	state := &BuildState{Services: map[string]ServiceBuildState{}}
+
+	data, err := os.ReadFile(stateFilePath(cwd))
+
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("[!] Failed to read the build state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("[!] Failed to parse the build state %s: %v", stateFilePath(cwd), err)
+	}
+
+	if state.Services == nil {
+		state.Services = map[string]ServiceBuildState{}
+	}
+
+	return state, nil
+}
+
+// recordBuild stores the image built for a service in an environment.
+func recordBuild(cwd, environment, serviceName string, serviceState ServiceBuildState) error {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	state, err := readBuildState(cwd)
+	if err != nil {
+		return err
+	}
+
+	state.Services[stateKey(environment, serviceName)] = serviceState
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(stateFilePath(cwd), append(data, '\n'))
+}
+
+// writeFileAtomically replaces a file through a rename, so an interrupted run never leaves
+// it half written.
+func writeFileAtomically(filePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filePath)
+}
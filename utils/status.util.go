@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// How the committed deployment YAML compares with the cluster
+const (
+	statusInSync      = "in-sync"
+	statusDrift       = "DRIFT" // upper case so it stands out in the table
+	statusNotDeployed = "not-deployed"
+	statusUnknown     = "unknown" // the YAML or the cluster could not be read
+)
+
+// ServiceStatus is the committed and the live state of one service.
+type ServiceStatus struct {
+	Target          ServiceTarget
+	Kind            string // workload kind of the deployment YAML, e.g. StatefulSet
+	CommittedImage  string // image in the local deployment YAML
+	LiveImage       string // image of the workload running in the cluster, empty when not deployed
+	ReadyReplicas   int64  // succeeded completions for a Job
+	DesiredReplicas int64
+	Restarts        int64     // container restarts summed over the workload's pods
+	LastRollout     time.Time // last time the rollout progressed or the job ran, zero when unknown
+	State           string
+	Err             error
+}
+
+// GetServiceStatus reads the committed image from the deployment YAML and the live state
+// of its workload from the --mode environment's cluster.
+func GetServiceStatus(cfg *types.K8sDeployerConfig, args *types.Args, cwd string, target ServiceTarget) *ServiceStatus {
+	status := &ServiceStatus{Target: target, State: statusUnknown}
+
+	env, err := GetEnvironment(cfg, args.DeployTo)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	serviceDirectoryRoot, err := GetServiceDirectoryRoot(cfg, cwd, target.Type, target.Name)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	deploymentYamlPath, _ := GetDeploymentAndServiceYamlPaths(cfg, env, serviceDirectoryRoot, target.Type, target.Name)
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, target.Name)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	status.Kind = workload.Kind
+	status.CommittedImage = workload.Image
+
+	name := workload.Name
+
+	cmd := kubectlCommand(env, "get", workload.resourceType(), name, "-o", "json", "--ignore-not-found")
+	cmd.Dir = serviceDirectoryRoot
+	cmd.Quiet = true
+
+	output, errOutput, err := runCommand(cmd)
+
+	if err != nil {
+		status.Err = fmt.Errorf("[!] Failed to get the state of %s '%s': %s", workload.resourceType(), name, commandError(err, errOutput))
+		return status
+	}
+
+	if strings.TrimSpace(output) == "" {
+		status.State = statusNotDeployed
+		return status
+	}
+
+	var state workloadState
+	if err := json.Unmarshal([]byte(output), &state); err != nil {
+		status.Err = fmt.Errorf("[!] Failed to parse the state of %s '%s': %v", workload.resourceType(), name, err)
+		return status
+	}
+
+	status.LiveImage = state.image(workload.Kind)
+	status.ReadyReplicas, status.DesiredReplicas = state.readiness(workload.Kind)
+	status.LastRollout = state.lastRollout(workload.Kind)
+
+	status.Restarts = podRestarts(env, serviceDirectoryRoot, state.Spec.Selector.MatchLabels)
+
+	if status.LiveImage == status.CommittedImage {
+		status.State = statusInSync
+	} else {
+		status.State = statusDrift
+	}
+
+	return status
+}
+
+// podRestarts sums the restart counts of the containers of the pods matching the labels.
+// Like the rollout watcher's pod listing it is best effort.
+func podRestarts(env *types.EnvironmentConfig, cwd string, matchLabels map[string]string) int64 {
+	if len(matchLabels) == 0 {
+		return 0
+	}
+
+	cmd := kubectlCommand(env, "get", "pods", "-l", labelSelector(matchLabels), "-o", "json")
+	cmd.Dir = cwd
+	cmd.Quiet = true
+
+	output, _, err := runCommand(cmd)
+
+	if err != nil {
+		return 0
+	}
+
+	var pods podList
+	if err := json.Unmarshal([]byte(output), &pods); err != nil {
+		return 0
+	}
+
+	var restarts int64
+
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			restarts += containerStatus.RestartCount
+		}
+	}
+
+	return restarts
+}
+
+// StatusDrifted reports whether a service does not run its committed image, or its status
+// could not be read.
+func StatusDrifted(statuses []*ServiceStatus) bool {
+	for _, status := range statuses {
+		if status.State == statusDrift || status.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrintStatus writes a table comparing the committed and the live image of every service,
+// followed by the services that drifted.
+func PrintStatus(w io.Writer, environment string, statuses []*ServiceStatus) {
+	fmt.Fprintf(w, "Status of %d service(s) in %s:\n", len(statuses), environment)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(table, "SERVICE\tTYPE\tSTATE\tCOMMITTED\tLIVE\tREADY\tRESTARTS\tLAST ROLLOUT")
+
+	var drifted []string
+
+	for _, status := range statuses {
+		ready, restarts, lastRollout := "-", "-", "-"
+
+		// A CronJob has no pods of its own between its jobs
+		if status.LiveImage != "" && status.Kind != cronJobKind {
+			ready = fmt.Sprintf("%d/%d", status.ReadyReplicas, status.DesiredReplicas)
+			restarts = fmt.Sprint(status.Restarts)
+		}
+
+		if !status.LastRollout.IsZero() {
+			lastRollout = status.LastRollout.Local().Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			status.Target.Name,
+			status.Target.Type,
+			status.State,
+			orDash(status.CommittedImage),
+			orDash(status.LiveImage),
+			ready,
+			restarts,
+			lastRollout,
+		)
+
+		if status.State == statusDrift {
+			drifted = append(drifted, status.Target.Name)
+		}
+	}
+
+	table.Flush()
+
+	for _, status := range statuses {
+		if status.Err != nil {
+			fmt.Fprintf(w, "\n[!] %s: %s\n", status.Target.Name, strings.TrimSpace(status.Err.Error()))
+		}
+	}
+
+	if len(drifted) > 0 {
+		fmt.Fprintf(w, "\n[!] The cluster does not run the committed image of: %s\n", strings.Join(drifted, ", "))
+	}
+}
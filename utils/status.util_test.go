@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const liveDeploymentJson = `{"metadata":{"generation":2},"spec":{"replicas":3,"selector":{"matchLabels":{"app":"udecrypt-image-service"}},` +
+	`"template":{"spec":{"containers":[{"image":"udecrypt_image:1.0.0"}]}}},` +
+	`"status":{"readyReplicas":2,"conditions":[{"type":"Available","status":"True"},` +
+	`{"type":"Progressing","status":"True","reason":"NewReplicaSetAvailable","lastUpdateTime":"2024-03-01T12:30:00Z"}]}}`
+
+const restartingPodsJson = `{"items":[` +
+	`{"status":{"containerStatuses":[{"name":"a","restartCount":2},{"name":"b","restartCount":1}]}},` +
+	`{"status":{"containerStatuses":[{"name":"a","restartCount":4}]}}]}`
+
+func TestGetServiceStatusReportsDrift(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get deployment "+testDeploymentName+" -o json", RecordedOutput{Stdout: liveDeploymentJson})
+	recorder.On("kubectl get pods -l app=udecrypt-image-service", RecordedOutput{Stdout: restartingPodsJson})
+
+	status := GetServiceStatus(cfg, &types.Args{DeployTo: "dev"}, cwd, ServiceTarget{Name: "image", Type: "go"})
+
+	if status.Err != nil {
+		t.Fatalf("GetServiceStatus failed: %v", status.Err)
+	}
+
+	if status.State != statusDrift || status.CommittedImage != "udecrypt_image:1.0.1" || status.LiveImage != "udecrypt_image:1.0.0" {
+		t.Errorf("expected drift between the YAML and the cluster, got %+v", status)
+	}
+
+	if !StatusDrifted([]*ServiceStatus{status}) {
+		t.Errorf("expected the drift to fail the status")
+	}
+
+	if status.ReadyReplicas != 2 || status.DesiredReplicas != 3 || status.Restarts != 7 || status.LastRollout.IsZero() {
+		t.Errorf("unexpected live state %+v", status)
+	}
+
+	var output bytes.Buffer
+	PrintStatus(&output, "dev", []*ServiceStatus{status})
+
+	if !strings.Contains(output.String(), "2/3") || !strings.Contains(output.String(), "does not run the committed image of: image") {
+		t.Errorf("unexpected status table:\n%s", output.String())
+	}
+}
+
+func TestGetServiceStatusOfAServiceNotDeployed(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	useRecordingRunner(t)
+
+	status := GetServiceStatus(cfg, &types.Args{DeployTo: "dev"}, cwd, ServiceTarget{Name: "image", Type: "go"})
+
+	if status.Err != nil || status.State != statusNotDeployed || status.LiveImage != "" {
+		t.Errorf("expected a service that is not deployed, got %+v", status)
+	}
+
+	if StatusDrifted([]*ServiceStatus{status}) {
+		t.Errorf("a service that is not deployed did not drift")
+	}
+
+	if !StatusDrifted([]*ServiceStatus{status, {State: statusUnknown, Err: errors.New("forbidden")}}) {
+		t.Errorf("expected an unreadable status to fail the status")
+	}
+}
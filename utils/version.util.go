@@ -0,0 +1,346 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+)
+
+var (
+	repositoryComponentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	registryPattern            = regexp.MustCompile(`^(?:[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*|\[[0-9a-fA-F:]+\])(?::[0-9]+)?$`)
+	tagPattern                 = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern              = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
+	semVerIdentifierPattern    = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+)
+
+// ImageReferenceError is returned when a Docker image reference can't be parsed.
+type ImageReferenceError struct {
+	Image  string
+	Reason string
+}
+
+func (e *ImageReferenceError) Error() string {
+	return fmt.Sprintf("[!] Invalid Docker image reference '%s': %s", e.Image, e.Reason)
+}
+
+// VersionError is returned when an image tag is not a valid semantic version.
+type VersionError struct {
+	Version string
+	Reason  string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("[!] Invalid version '%s': %s", e.Version, e.Reason)
+}
+
+// ParseVersion takes a Docker image string and returns its tag as the current version.
+// If the image does not contain a tag, it uses the default version 1.0.0.
+func ParseVersion(dockerImage string) (string, error) {
+	reference, err := ParseImageReference(dockerImage)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("[+] Image: ", dockerImage)
+
+	if reference.Tag == "" {
+		fmt.Println("[!] No version was set in YAML for the Docker image. Using default version: 1.0.0")
+
+		return "1.0.0", nil
+	}
+
+	fmt.Println("[+] Current Version: ", reference.Tag)
+
+	return reference.Tag, nil
+}
+
+// ParseTagVersion parses an image tag as a semantic version. `+` is not allowed in Docker
+// tags, so build metadata is conventionally written after `_` instead.
+func ParseTagVersion(tag string) (*SemVer, error) {
+	return ParseSemVer(strings.Replace(tag, "_", "+", 1))
+}
+
+// BumpVersion increments a semantic version tag by the given level ("major", "minor",
+// "patch" or "prerelease") and drops its build metadata.
+//
+// A prerelease is bumped to its own release by "patch" (1.2.3-rc.1 -> 1.2.3), and to the
+// next prerelease by "prerelease" (1.2.3-rc.1 -> 1.2.3-rc.2, 1.2.3 -> 1.2.4-rc.0).
+func BumpVersion(tag, level, prereleaseIdentifier string) (string, error) {
+	version, err := ParseTagVersion(tag)
+	if err != nil {
+		return "", err
+	}
+
+	next := *version
+	next.Build = nil
+
+	switch level {
+	case constants.MajorBump:
+		if len(next.Prerelease) == 0 || next.Minor != 0 || next.Patch != 0 {
+			next.Major = next.Major + 1
+		}
+
+		next.Minor, next.Patch, next.Prerelease = 0, 0, nil
+	case constants.MinorBump:
+		if len(next.Prerelease) == 0 || next.Patch != 0 {
+			next.Minor = next.Minor + 1
+		}
+
+		next.Patch, next.Prerelease = 0, nil
+	case constants.PatchBump:
+		if len(next.Prerelease) == 0 {
+			next.Patch = next.Patch + 1
+		}
+
+		next.Prerelease = nil
+	case constants.PrereleaseBump:
+		next.Prerelease = bumpPrerelease(next.Prerelease, prereleaseIdentifier)
+
+		if len(version.Prerelease) == 0 {
+			next.Patch = next.Patch + 1
+		}
+	default:
+		return "", &UsageError{Reason: fmt.Sprintf(
+			"Unknown version bump '%s' (expected %s, %s, %s or %s)",
+			level,
+			constants.MajorBump,
+			constants.MinorBump,
+			constants.PatchBump,
+			constants.PrereleaseBump,
+		)}
+	}
+
+	return next.String(), nil
+}
+
+func bumpPrerelease(prerelease []string, identifier string) []string {
+	if len(prerelease) == 0 || (identifier != "" && prerelease[0] != identifier) {
+		return []string{identifier, "0"}
+	}
+
+	next := append([]string{}, prerelease...)
+
+	for i := len(next) - 1; i >= 0; i-- {
+		if isNumeric(next[i]) {
+			number, _ := strconv.ParseUint(next[i], 10, 64)
+			next[i] = strconv.FormatUint(number+1, 10)
+
+			return next
+		}
+	}
+
+	return append(next, "0")
+}
+
+// ImageReference is a parsed `[registry[:port]/]repository[:tag][@digest]` Docker image reference.
+type ImageReference struct {
+	Registry   string // e.g. "registry.gitlab.com" or "localhost:5000", empty for Docker Hub
+	Repository string // e.g. "udecrypt/server/udecrypt_image"
+	Tag        string
+	Digest     string // e.g. "sha256:..."
+}
+
+// ParseImageReference splits a Docker image reference into its parts. The first path
+// component is only treated as a registry host when it looks like one (contains a dot or a
+// port, or is localhost), the same way Docker itself decides.
+func ParseImageReference(image string) (*ImageReference, error) {
+	if image == "" {
+		return nil, &ImageReferenceError{Image: image, Reason: "the reference is empty"}
+	}
+
+	reference := &ImageReference{}
+	name := image
+
+	if at := strings.Index(name, "@"); at >= 0 {
+		reference.Digest = name[at+1:]
+		name = name[:at]
+
+		if !digestPattern.MatchString(reference.Digest) {
+			return nil, &ImageReferenceError{Image: image, Reason: fmt.Sprintf("invalid digest '%s'", reference.Digest)}
+		}
+	}
+
+	if slash := strings.Index(name, "/"); slash >= 0 {
+		host := name[:slash]
+
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			if !registryPattern.MatchString(host) {
+				return nil, &ImageReferenceError{Image: image, Reason: fmt.Sprintf("invalid registry host '%s'", host)}
+			}
+
+			reference.Registry = host
+			name = name[slash+1:]
+		}
+	}
+
+	lastSlash := strings.LastIndex(name, "/")
+
+	if colon := strings.LastIndex(name, ":"); colon > lastSlash {
+		reference.Tag = name[colon+1:]
+		name = name[:colon]
+
+		if !tagPattern.MatchString(reference.Tag) {
+			return nil, &ImageReferenceError{Image: image, Reason: fmt.Sprintf("invalid tag '%s'", reference.Tag)}
+		}
+	}
+
+	if name == "" {
+		return nil, &ImageReferenceError{Image: image, Reason: "the repository name is empty"}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if !repositoryComponentPattern.MatchString(component) {
+			return nil, &ImageReferenceError{Image: image, Reason: fmt.Sprintf("invalid repository path component '%s'", component)}
+		}
+	}
+
+	reference.Repository = name
+
+	return reference, nil
+}
+
+// Name returns the reference without its tag and digest.
+func (r *ImageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+
+	return r.Registry + "/" + r.Repository
+}
+
+func (r *ImageReference) String() string {
+	reference := r.Name()
+
+	if r.Tag != "" {
+		reference += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		reference += "@" + r.Digest
+	}
+
+	return reference
+}
+
+// SemVer is a Semantic Versioning 2.0.0 version, optionally written with a "v" prefix.
+type SemVer struct {
+	Prefix     string // "v" when the version was written as v1.2.3
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// ParseSemVer parses a version such as `1.2.3`, `v1.10.0-rc.1` or `2.0.0+build.5`.
+func ParseSemVer(version string) (*SemVer, error) {
+	semVer := &SemVer{}
+	rest := version
+
+	if strings.HasPrefix(rest, "v") || strings.HasPrefix(rest, "V") {
+		semVer.Prefix = rest[:1]
+		rest = rest[1:]
+	}
+
+	if plus := strings.Index(rest, "+"); plus >= 0 {
+		build, err := parseSemVerIdentifiers(version, rest[plus+1:], false)
+		if err != nil {
+			return nil, err
+		}
+
+		semVer.Build = build
+		rest = rest[:plus]
+	}
+
+	if dash := strings.Index(rest, "-"); dash >= 0 {
+		prerelease, err := parseSemVerIdentifiers(version, rest[dash+1:], true)
+		if err != nil {
+			return nil, err
+		}
+
+		semVer.Prerelease = prerelease
+		rest = rest[:dash]
+	}
+
+	parts := strings.Split(rest, ".")
+
+	if len(parts) != 3 {
+		return nil, &VersionError{Version: version, Reason: "expected MAJOR.MINOR.PATCH"}
+	}
+
+	numbers := make([]uint64, 3)
+
+	for i, part := range parts {
+		number, err := parseSemVerNumber(version, part)
+		if err != nil {
+			return nil, err
+		}
+
+		numbers[i] = number
+	}
+
+	semVer.Major, semVer.Minor, semVer.Patch = numbers[0], numbers[1], numbers[2]
+
+	return semVer, nil
+}
+
+func parseSemVerNumber(version, part string) (uint64, error) {
+	if part == "" {
+		return 0, &VersionError{Version: version, Reason: "version numbers can't be empty"}
+	}
+
+	if len(part) > 1 && part[0] == '0' {
+		return 0, &VersionError{Version: version, Reason: fmt.Sprintf("'%s' has a leading zero", part)}
+	}
+
+	number, err := strconv.ParseUint(part, 10, 64)
+	if err != nil {
+		return 0, &VersionError{Version: version, Reason: fmt.Sprintf("'%s' is not a number", part)}
+	}
+
+	return number, nil
+}
+
+func parseSemVerIdentifiers(version, identifiers string, prerelease bool) ([]string, error) {
+	parts := strings.Split(identifiers, ".")
+
+	for _, part := range parts {
+		if !semVerIdentifierPattern.MatchString(part) {
+			return nil, &VersionError{Version: version, Reason: fmt.Sprintf("invalid identifier '%s'", part)}
+		}
+
+		if prerelease && isNumeric(part) && len(part) > 1 && part[0] == '0' {
+			return nil, &VersionError{Version: version, Reason: fmt.Sprintf("prerelease identifier '%s' has a leading zero", part)}
+		}
+	}
+
+	return parts, nil
+}
+
+func isNumeric(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return value != ""
+}
+
+func (v *SemVer) String() string {
+	version := fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+
+	if len(v.Prerelease) > 0 {
+		version += "-" + strings.Join(v.Prerelease, ".")
+	}
+
+	if len(v.Build) > 0 {
+		version += "+" + strings.Join(v.Build, ".")
+	}
+
+	return version
+}
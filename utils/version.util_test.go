@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	cases := []struct {
+		image    string
+		expected ImageReference
+	}{
+		{"udecrypt_image", ImageReference{Repository: "udecrypt_image"}},
+		{"udecrypt_image:1.10.3", ImageReference{Repository: "udecrypt_image", Tag: "1.10.3"}},
+		{"udecrypt/server/image:v1.2.3-rc.1", ImageReference{Repository: "udecrypt/server/image", Tag: "v1.2.3-rc.1"}},
+		{"host:5000/img:tag", ImageReference{Registry: "host:5000", Repository: "img", Tag: "tag"}},
+		{"host:5000/img", ImageReference{Registry: "host:5000", Repository: "img"}},
+		{"localhost/img:12.0.0", ImageReference{Registry: "localhost", Repository: "img", Tag: "12.0.0"}},
+		{"registry.gitlab.com/udecrypt/img:1.0.0", ImageReference{Registry: "registry.gitlab.com", Repository: "udecrypt/img", Tag: "1.0.0"}},
+		{"[::1]:5000/img:1.0.0", ImageReference{Registry: "[::1]:5000", Repository: "img", Tag: "1.0.0"}},
+		{"img@" + digest, ImageReference{Repository: "img", Digest: digest}},
+		{"host:5000/img:1.2.3@" + digest, ImageReference{Registry: "host:5000", Repository: "img", Tag: "1.2.3", Digest: digest}},
+	}
+
+	for _, c := range cases {
+		reference, err := ParseImageReference(c.image)
+		if err != nil {
+			t.Errorf("ParseImageReference(%q) returned an error: %v", c.image, err)
+			continue
+		}
+
+		if *reference != c.expected {
+			t.Errorf("ParseImageReference(%q) = %+v, expected %+v", c.image, *reference, c.expected)
+		}
+
+		if reference.String() != c.image {
+			t.Errorf("ParseImageReference(%q).String() = %q", c.image, reference.String())
+		}
+	}
+}
+
+func TestParseImageReferenceRejectsInvalidReferences(t *testing.T) {
+	for _, image := range []string{
+		"",
+		":1.0.0",
+		"Upper/img:1.0.0",
+		"img:-bad",
+		"img:1.0.0+build",
+		"img@sha256:short",
+		"bad_host.com:port/img:1.0.0",
+		"host:5000/:1.0.0",
+	} {
+		var referenceErr *ImageReferenceError
+
+		if _, err := ParseImageReference(image); !errors.As(err, &referenceErr) {
+			t.Errorf("ParseImageReference(%q) = %v, expected an *ImageReferenceError", image, err)
+		}
+	}
+}
+
+func TestParseSemVer(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected SemVer
+	}{
+		{"1.9.0", SemVer{Major: 1, Minor: 9}},
+		{"1.10.3", SemVer{Major: 1, Minor: 10, Patch: 3}},
+		{"12.0.0", SemVer{Major: 12}},
+		{"v1.2.3-rc.1", SemVer{Prefix: "v", Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"rc", "1"}}},
+		{"2.0.0+build.5", SemVer{Major: 2, Build: []string{"build", "5"}}},
+		{"1.0.0-alpha-1.0+sha.abc1234", SemVer{Major: 1, Prerelease: []string{"alpha-1", "0"}, Build: []string{"sha", "abc1234"}}},
+	}
+
+	for _, c := range cases {
+		version, err := ParseSemVer(c.version)
+		if err != nil {
+			t.Errorf("ParseSemVer(%q) returned an error: %v", c.version, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(*version, c.expected) {
+			t.Errorf("ParseSemVer(%q) = %+v, expected %+v", c.version, *version, c.expected)
+		}
+
+		if version.String() != c.version {
+			t.Errorf("ParseSemVer(%q).String() = %q", c.version, version.String())
+		}
+	}
+}
+
+func TestParseSemVerRejectsInvalidVersions(t *testing.T) {
+	for _, version := range []string{
+		"",
+		"1.2",
+		"1.2.3.4",
+		"01.2.3",
+		"1.2.x",
+		"1.2.3-",
+		"1.2.3-rc.01",
+		"1.2.3-rc..1",
+		"1.2.3+",
+		"latest",
+	} {
+		var versionErr *VersionError
+
+		if _, err := ParseSemVer(version); !errors.As(err, &versionErr) {
+			t.Errorf("ParseSemVer(%q) = %v, expected a *VersionError", version, err)
+		}
+	}
+}
+
+func TestParseVersionKeepsMultiDigitComponents(t *testing.T) {
+	for image, expected := range map[string]string{
+		"udecrypt_image:1.10.3":                   "1.10.3",
+		"udecrypt_image:12.0.0":                   "12.0.0",
+		"host:5000/udecrypt_image:v1.2.3-rc.1":    "v1.2.3-rc.1",
+		"host:5000/udecrypt_image":                "1.0.0",
+		"registry.example.com/udecrypt/img:1.9.0": "1.9.0",
+	} {
+		if version, err := ParseVersion(image); err != nil || version != expected {
+			t.Errorf("ParseVersion(%q) = %q, %v, expected %q", image, version, err, expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const defaultTimestampFormat = "20060102150405"
+
+// Conventional commit header, e.g. `feat(api)!: drop v1 endpoints`
+var conventionalCommitPattern = regexp.MustCompile(`^(\w+)(?:\([^)]*\))?(!)?:\s`)
+
+// NextVersion computes the version of the image about to be built from the current tag.
+// An explicit --version wins, then --bump, then the service's versioning strategy. Whatever
+// it comes from, the version has to be a valid Docker image tag.
+func NextVersion(
+	versioning types.VersioningConfig,
+	args *types.Args,
+	serviceDirectoryRoot,
+	currentVersion string,
+) (string, error) {
+	if args.Version != "" {
+		if !tagPattern.MatchString(args.Version) {
+			return "", &VersionError{Version: args.Version, Reason: "not a valid Docker image tag"}
+		}
+
+		return args.Version, nil
+	}
+
+	version, err := nextVersion(versioning, args, serviceDirectoryRoot, currentVersion)
+	if err != nil {
+		return "", err
+	}
+
+	if !tagPattern.MatchString(version) {
+		return "", &VersionError{
+			Version: version,
+			Reason:  fmt.Sprintf("the '%s' versioning strategy did not produce a valid Docker image tag", versioning.Strategy),
+		}
+	}
+
+	return version, nil
+}
+
+func nextVersion(versioning types.VersioningConfig, args *types.Args, serviceDirectoryRoot, currentVersion string) (string, error) {
+	if args.Bump != "" {
+		if versioning.Strategy != constants.SemVerVersioning && versioning.Strategy != constants.ConventionalCommitsVersioning {
+			return "", &UsageError{Reason: fmt.Sprintf("--bump can't be used with the '%s' versioning strategy", versioning.Strategy)}
+		}
+
+		return BumpVersion(currentVersion, args.Bump, versioning.PrereleaseIdentifier)
+	}
+
+	switch versioning.Strategy {
+	case constants.SemVerVersioning:
+		return BumpVersion(currentVersion, constants.PatchBump, versioning.PrereleaseIdentifier)
+	case constants.GitShaVersioning:
+		return gitShortSha(serviceDirectoryRoot)
+	case constants.TimestampVersioning:
+		return time.Now().UTC().Format(versioning.TimestampFormat), nil
+	case constants.CalVerVersioning:
+		return nextCalVer(currentVersion, time.Now().UTC()), nil
+	case constants.ConventionalCommitsVersioning:
+		level, err := conventionalCommitsBump(serviceDirectoryRoot, versioning.TagPattern)
+		if err != nil {
+			return "", err
+		}
+
+		if level == "" {
+			fmt.Printf("[+] No commits touched the service since the last release, keeping version %s\n", currentVersion)
+
+			return currentVersion, nil
+		}
+
+		fmt.Printf("[+] Conventional commits call for a %s bump\n", level)
+
+		return BumpVersion(currentVersion, level, versioning.PrereleaseIdentifier)
+	default:
+		return "", &ConfigError{Reason: fmt.Sprintf("Unknown versioning strategy '%s'", versioning.Strategy)}
+	}
+}
+
+// nextCalVer returns YYYY.MM.MICRO, where MICRO counts the builds made within the month.
+func nextCalVer(currentVersion string, now time.Time) string {
+	year, month := uint64(now.Year()), uint64(now.Month())
+	micro := uint64(0)
+
+	if current, err := ParseTagVersion(currentVersion); err == nil && current.Major == year && current.Minor == month {
+		micro = current.Patch + 1
+	}
+
+	return fmt.Sprintf("%d.%d.%d", year, month, micro)
+}
+
+// conventionalCommitsBump reads the commits touching serviceDirectoryRoot since the last
+// git tag matching tagPattern and returns the bump level they call for: "major" for breaking
+// changes, "minor" for features and "patch" for anything else. It is empty when no commit
+// touched the service, so unchanged code keeps its version.
+func conventionalCommitsBump(serviceDirectoryRoot, tagPattern string) (string, error) {
+	revisionRange := "HEAD"
+
+	if tag, err := gitOutput(serviceDirectoryRoot, "describe", "--tags", "--abbrev=0", "--match", tagPattern); err == nil && tag != "" {
+		fmt.Printf("[+] Reading commits since tag %s\n", tag)
+
+		revisionRange = tag + "..HEAD"
+	} else {
+		fmt.Printf("[!] No git tag matching '%s' found, reading the whole history\n", tagPattern)
+	}
+
+	log, err := gitOutput(serviceDirectoryRoot, "log", "--format=%B%x00", revisionRange, "--", ".")
+	if err != nil {
+		return "", err
+	}
+
+	level := ""
+
+	for _, message := range strings.Split(log, "\x00") {
+		message = strings.TrimSpace(message)
+
+		if message == "" {
+			continue
+		}
+
+		header := strings.SplitN(message, "\n", 2)[0]
+		match := conventionalCommitPattern.FindStringSubmatch(header)
+
+		if match != nil && match[2] == "!" ||
+			strings.Contains(message, "\nBREAKING CHANGE:") ||
+			strings.Contains(message, "\nBREAKING-CHANGE:") {
+			return constants.MajorBump, nil
+		}
+
+		if match != nil && match[1] == "feat" {
+			level = constants.MinorBump
+		} else if level == "" {
+			level = constants.PatchBump
+		}
+	}
+
+	return level, nil
+}
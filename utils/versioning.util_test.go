@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+func TestBumpVersion(t *testing.T) {
+	cases := []struct {
+		tag, level, identifier, expected string
+	}{
+		{"1.2.3", constants.MajorBump, "rc", "2.0.0"},
+		{"1.2.3", constants.MinorBump, "rc", "1.3.0"},
+		{"1.2.3", constants.PatchBump, "rc", "1.2.4"},
+		{"1.2.3", constants.PrereleaseBump, "rc", "1.2.4-rc.0"},
+		{"1.9.0", constants.MinorBump, "rc", "1.10.0"},
+		{"1.10.3", constants.PatchBump, "rc", "1.10.4"},
+		{"1.2.99", constants.PatchBump, "rc", "1.2.100"},
+		{"v1.2.3", constants.PatchBump, "rc", "v1.2.4"},
+		{"1.2.3_build.5", constants.PatchBump, "rc", "1.2.4"},
+		{"1.2.3-rc.1", constants.PatchBump, "rc", "1.2.3"},
+		{"1.2.3-rc.1", constants.PrereleaseBump, "rc", "1.2.3-rc.2"},
+		{"1.2.3-rc.1", constants.PrereleaseBump, "beta", "1.2.3-beta.0"},
+		{"1.2.3-alpha", constants.PrereleaseBump, "", "1.2.3-alpha.0"},
+		{"1.3.0-rc.1", constants.MinorBump, "rc", "1.3.0"},
+		{"1.3.1-rc.1", constants.MinorBump, "rc", "1.4.0"},
+		{"2.0.0-rc.1", constants.MajorBump, "rc", "2.0.0"},
+		{"2.1.0-rc.1", constants.MajorBump, "rc", "3.0.0"},
+	}
+
+	for _, c := range cases {
+		if next, err := BumpVersion(c.tag, c.level, c.identifier); err != nil || next != c.expected {
+			t.Errorf("BumpVersion(%q, %s, %q) = %q, %v, expected %q", c.tag, c.level, c.identifier, next, err, c.expected)
+		}
+	}
+
+	var usageErr *UsageError
+	if _, err := BumpVersion("1.2.3", "huge", "rc"); !errors.As(err, &usageErr) {
+		t.Errorf("expected a *UsageError for an unknown bump, got %v", err)
+	}
+
+	var versionErr *VersionError
+	if _, err := BumpVersion("latest", constants.PatchBump, "rc"); !errors.As(err, &versionErr) {
+		t.Errorf("expected a *VersionError for a tag that is not a version, got %v", err)
+	}
+}
+
+func TestNextCalVer(t *testing.T) {
+	march := time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)
+
+	for current, expected := range map[string]string{
+		"2024.3.4": "2024.3.5",
+		"2024.2.9": "2024.3.0",
+		"1.2.3":    "2024.3.0",
+		"latest":   "2024.3.0",
+	} {
+		if next := nextCalVer(current, march); next != expected {
+			t.Errorf("nextCalVer(%q) = %q, expected %q", current, next, expected)
+		}
+	}
+}
+
+func TestNextVersionStrategies(t *testing.T) {
+	const serviceDirectory = "/src/services/go/image"
+
+	cases := []struct {
+		name       string
+		versioning types.VersioningConfig
+		args       types.Args
+		git        map[string]string // command prefix to its output
+		expected   string
+	}{
+		{name: "semver", versioning: types.VersioningConfig{Strategy: constants.SemVerVersioning}, expected: "1.2.4"},
+		{name: "explicit version", versioning: types.VersioningConfig{Strategy: constants.GitShaVersioning}, args: types.Args{Version: "2024-hotfix"}, expected: "2024-hotfix"},
+		{name: "bump", versioning: types.VersioningConfig{Strategy: constants.SemVerVersioning}, args: types.Args{Bump: constants.MinorBump}, expected: "1.3.0"},
+		{
+			name:       "git-sha",
+			versioning: types.VersioningConfig{Strategy: constants.GitShaVersioning},
+			git:        map[string]string{"git rev-parse": "abc1234\n", "git status": ""},
+			expected:   "abc1234",
+		},
+		{
+			name:       "dirty git-sha",
+			versioning: types.VersioningConfig{Strategy: constants.GitShaVersioning},
+			git:        map[string]string{"git rev-parse": "abc1234\n", "git status": " M main.go\n"},
+			expected:   "abc1234-dirty",
+		},
+		{
+			name:       "conventional fix",
+			versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"},
+			git:        map[string]string{"git describe": "v1.2.3\n", "git log": "fix: handle empty tags\n\x00\nchore: tidy\n\x00\n"},
+			expected:   "1.2.4",
+		},
+		{
+			name:       "conventional feature",
+			versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"},
+			git:        map[string]string{"git describe": "v1.2.3\n", "git log": "fix: x\n\x00\nfeat(api): add search\n\x00\n"},
+			expected:   "1.3.0",
+		},
+		{
+			name:       "conventional breaking header",
+			versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"},
+			git:        map[string]string{"git describe": "v1.2.3\n", "git log": "feat!: drop v1\n\x00\n"},
+			expected:   "2.0.0",
+		},
+		{
+			name:       "conventional breaking footer",
+			versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"},
+			git:        map[string]string{"git describe": "v1.2.3\n", "git log": "refactor: auth\n\nBREAKING CHANGE: tokens expire\n\x00\n"},
+			expected:   "2.0.0",
+		},
+		{
+			name:       "conventional without commits",
+			versioning: types.VersioningConfig{Strategy: constants.ConventionalCommitsVersioning, TagPattern: "*"},
+			git:        map[string]string{"git describe": "v1.2.3\n", "git log": ""},
+			expected:   "1.2.3",
+		},
+	}
+
+	for _, c := range cases {
+		recorder := useRecordingRunner(t)
+
+		for prefix, output := range c.git {
+			recorder.On(prefix, RecordedOutput{Stdout: output})
+		}
+
+		if next, err := NextVersion(c.versioning, &c.args, serviceDirectory, "1.2.3"); err != nil || next != c.expected {
+			t.Errorf("%s: NextVersion = %q, %v, expected %q", c.name, next, err, c.expected)
+		}
+	}
+}
+
+func TestNextVersionTimestamp(t *testing.T) {
+	versioning := types.VersioningConfig{Strategy: constants.TimestampVersioning, TimestampFormat: "20060102.1504"}
+
+	next, err := NextVersion(versioning, &types.Args{}, "", "1.2.3")
+	if err != nil {
+		t.Fatalf("NextVersion returned an error: %v", err)
+	}
+
+	if _, err := time.Parse(versioning.TimestampFormat, next); err != nil {
+		t.Errorf("expected a %s timestamp, got %q", versioning.TimestampFormat, next)
+	}
+
+	var usageErr *UsageError
+	if _, err := NextVersion(versioning, &types.Args{Bump: constants.PatchBump}, "", "1.2.3"); !errors.As(err, &usageErr) {
+		t.Errorf("expected --bump to be refused with timestamps, got %v", err)
+	}
+}
+
+func TestNextVersionRejectsInvalidTags(t *testing.T) {
+	recorder := useRecordingRunner(t)
+	recorder.On("git rev-parse", RecordedOutput{Stdout: "\n"})
+
+	for name, versioning := range map[string]types.VersioningConfig{
+		"timestamp with a space": {Strategy: constants.TimestampVersioning, TimestampFormat: "2006-01-02 15:04"},
+		"timestamp with a colon": {Strategy: constants.TimestampVersioning, TimestampFormat: "20060102T15:04"},
+		"empty git sha":          {Strategy: constants.GitShaVersioning},
+	} {
+		var versionErr *VersionError
+
+		if next, err := NextVersion(versioning, &types.Args{}, "/src/services/go/image", "1.2.3"); !errors.As(err, &versionErr) {
+			t.Errorf("%s: expected a *VersionError, got %q, %v", name, next, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+// Workload kinds whose image k8s-deployer bumps and whose rollout it watches
+const (
+	deploymentKind  = "Deployment"
+	statefulSetKind = "StatefulSet"
+	daemonSetKind   = "DaemonSet"
+	jobKind         = "Job"
+	cronJobKind     = "CronJob"
+)
+
+var workloadKinds = []string{deploymentKind, statefulSetKind, daemonSetKind, jobKind, cronJobKind}
+
+// Workload is the object of a deployment YAML file that runs the service's image.
+type Workload struct {
+	Kind  string
+	Name  string
+	Image string // image of the first container of its pod template
+}
+
+// resourceType is the kind as kubectl takes it, e.g. "statefulset".
+func (w *Workload) resourceType() string {
+	return strings.ToLower(w.Kind)
+}
+
+// Resource is the workload as kubectl addresses it, e.g. "statefulset/udecrypt-image-service".
+func (w *Workload) Resource() string {
+	return w.resourceType() + "/" + w.Name
+}
+
+// revisioned reports whether the cluster keeps a revision history of the workload that
+// `kubectl rollout undo` can go back through.
+func (w *Workload) revisioned() bool {
+	return w.Kind == deploymentKind || w.Kind == statefulSetKind || w.Kind == daemonSetKind
+}
+
+// podTemplateSpecPath is the path of the pod template spec in a manifest of the kind.
+// A manifest without a kind is taken for a Deployment.
+func podTemplateSpecPath(kind string) []string {
+	if kind == cronJobKind {
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	}
+
+	return []string{"spec", "template", "spec"}
+}
+
+// ParseWorkload decodes the workload of a deployment YAML file: the first document with pod
+// template containers, so the file may also hold other objects such as a ConfigMap. A
+// workload without a kind is a Deployment, and one without a name gets the
+// `<service>-deployment` name k8s-deployer has always used.
+func ParseWorkload(cfg *types.K8sDeployerConfig, yamlPath, serviceName string) (*Workload, error) {
+	data, err := readManifest(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := decodeYamlDocuments(data)
+	if err != nil {
+		return nil, &ManifestError{Path: yamlPath, Reason: fmt.Sprintf("error parsing YAML file: %v", err)}
+	}
+
+	document := workloadDocument(documents)
+	if document == nil {
+		return nil, &ManifestError{Path: yamlPath, Reason: "Failed to parse deployment YAML file"}
+	}
+
+	imageKey, imageValue := findContainerImageNode(document)
+	if imageKey == nil {
+		return nil, &ManifestError{Path: yamlPath, Reason: "Failed to find container in deployment YAML file"}
+	}
+
+	root := documentRoot(document)
+
+	_, kind := mappingEntry(root, "kind")
+	_, metadata := mappingEntry(root, "metadata")
+	_, name := mappingEntry(metadata, "name")
+
+	workload := &Workload{Kind: scalarValue(kind), Name: scalarValue(name), Image: scalarValue(imageValue)}
+
+	if workload.Kind == "" {
+		workload.Kind = deploymentKind
+	}
+
+	supported := false
+
+	for _, workloadKind := range workloadKinds {
+		supported = supported || workload.Kind == workloadKind
+	}
+
+	if !supported {
+		return nil, &ManifestError{
+			Path:   yamlPath,
+			Reason: fmt.Sprintf("Unsupported workload kind '%s', expected one of %s", workload.Kind, strings.Join(workloadKinds, ", ")),
+		}
+	}
+
+	if workload.Name == "" {
+		workload.Name = deploymentName(ParseServiceName(cfg.DockerImagePrefix, serviceName))
+	}
+
+	return workload, nil
+}
+
+// deleteExistingWorkload deletes the workload so that it is created again from its YAML.
+func deleteExistingWorkload(env *types.EnvironmentConfig, label string, workload *Workload) {
+	fmt.Printf("[+] Deleting existing %s...\n", workload.resourceType())
+
+	cmd := kubectlCommand(env, "delete", workload.resourceType(), workload.Name)
+	cmd.Label = label
+
+	_, _, err := runCommand(cmd)
+
+	if err != nil {
+		fmt.Printf("[!] Failed to delete existing %s or maybe there wasn't any %s yet: %v\n", workload.resourceType(), workload.resourceType(), err)
+		return
+	}
+
+	fmt.Printf("[+] Deleted existing %s if any\n", workload.resourceType())
+}
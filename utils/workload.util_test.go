@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
+)
+
+const testCronJobYaml = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: image-cleanup
+spec:
+  schedule: "0 3 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+          containers:
+            - name: cleanup
+              image: udecrypt_image:1.0.1
+`
+
+const testStatefulSetYaml = `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: image-store
+spec:
+  serviceName: image-store
+  replicas: 2
+  selector:
+    matchLabels:
+      app: image-store
+  template:
+    metadata:
+      labels:
+        app: image-store
+    spec:
+      containers:
+        - name: image-store
+          image: udecrypt_image:1.0.1
+`
+
+func statefulSetStateJson(updated, ready int, currentRevision string) string {
+	return fmt.Sprintf(
+		`{"metadata":{"generation":2},"spec":{"replicas":2,"selector":{"matchLabels":{"app":"image-store"}}},`+
+			`"status":{"observedGeneration":2,"replicas":2,"updatedReplicas":%d,"readyReplicas":%d,`+
+			`"currentRevision":%q,"updateRevision":"image-store-2"}}`,
+		updated, ready, currentRevision,
+	)
+}
+
+func TestBuildAndDeployACronJob(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On("kubectl get cronjob image-cleanup -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.1"})
+
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+	writeTestFile(t, deploymentYamlPath, testCronJobYaml)
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	if yaml := readTestFile(t, deploymentYamlPath); yaml != strings.Replace(testCronJobYaml, "1.0.1", "1.0.2", 1) {
+		t.Errorf("the job template image was not bumped:\n%s", yaml)
+	}
+
+	if err := DeployAfterBuild(cfg, args, buildInfo, "image"); err != nil {
+		t.Fatalf("DeployAfterBuild returned an error: %v", err)
+	}
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl get cronjob image-cleanup -o jsonpath") &&
+			!strings.Contains(line, "{.spec.jobTemplate.spec.template.spec.containers[0].image}") {
+			t.Errorf("the live image was not read from the job template: %s", line)
+		}
+
+		if line == "kubectl get cronjob image-cleanup -o json" {
+			t.Errorf("a CronJob has no rollout to wait for, got %s", line)
+		}
+	}
+}
+
+func TestDeployRollsBackAStatefulSetByItsName(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+	recorder := useRecordingRunner(t)
+	recorder.On(
+		"kubectl get statefulset image-store -o json",
+		RecordedOutput{Stdout: statefulSetStateJson(1, 1, "image-store-1")},
+		RecordedOutput{Stdout: statefulSetStateJson(2, 2, "image-store-2")},
+	)
+	recorder.On("kubectl get statefulset image-store -o jsonpath", RecordedOutput{Stdout: "udecrypt_image:1.0.1"})
+	recorder.On("kubectl get pods -l app=image-store", RecordedOutput{Stdout: crashingPodsJson("udecrypt_image:1.0.2")})
+
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+	writeTestFile(t, deploymentYamlPath, testStatefulSetYaml)
+
+	args := &types.Args{DeployTo: "dev", MicroserviceType: "go"}
+
+	buildInfo, err := Build(cfg, args, cwd, "image")
+	if err != nil {
+		t.Fatalf("Build returned an error: %v", err)
+	}
+
+	err = DeployAfterBuild(cfg, args, buildInfo, "image")
+
+	var rolloutErr *RolloutError
+	if !errors.As(err, &rolloutErr) || !rolloutErr.RolledBack || rolloutErr.Name != "image-store" {
+		t.Fatalf("expected a rolled back *RolloutError for image-store, got %v", err)
+	}
+
+	var kubectl []string
+
+	for _, line := range recorder.CommandLines() {
+		if strings.HasPrefix(line, "kubectl") && !strings.HasPrefix(line, "kubectl get pods") {
+			kubectl = append(kubectl, line)
+		}
+	}
+
+	expected := []string{
+		"kubectl get statefulset image-store -o jsonpath={.spec.template.spec.containers[0].image} --ignore-not-found",
+		"kubectl apply -f " + filepath.Join(cwd, "services", "go", "image", "k8s", "service.yaml"),
+		"kubectl apply -f " + deploymentYamlPath,
+		"kubectl get statefulset image-store -o json",
+		"kubectl rollout undo statefulset/image-store",
+		"kubectl get statefulset image-store -o jsonpath={.spec.template.spec.containers[0].image} --ignore-not-found",
+		"kubectl get statefulset image-store -o json",
+	}
+
+	if !reflect.DeepEqual(kubectl, expected) {
+		t.Errorf("unexpected commands:\n%s\nexpected:\n%s", strings.Join(kubectl, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if yaml := readTestFile(t, deploymentYamlPath); yaml != testStatefulSetYaml {
+		t.Errorf("deployment YAML was not restored:\n%s", yaml)
+	}
+}
+
+func TestStatefulSetRolloutHonoursTheUpdateStrategy(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy string
+		updated  int
+		ready    int
+		done     bool
+	}{
+		{"rolling update in progress", `{"type":"RollingUpdate"}`, 2, 2, false},
+		{"on delete", `{"type":"OnDelete"}`, 0, 2, true},
+		{"partition reached", `{"type":"RollingUpdate","rollingUpdate":{"partition":1}}`, 1, 2, true},
+		{"partition not reached", `{"type":"RollingUpdate","rollingUpdate":{"partition":1}}`, 0, 2, false},
+		{"partition not ready", `{"type":"RollingUpdate","rollingUpdate":{"partition":1}}`, 1, 1, false},
+	}
+
+	for _, c := range cases {
+		// The current revision stays behind in every case
+		stateJson := strings.Replace(statefulSetStateJson(c.updated, c.ready, "image-store-1"), `"spec":{`, `"spec":{"updateStrategy":`+c.strategy+`,`, 1)
+
+		var state workloadState
+		if err := json.Unmarshal([]byte(stateJson), &state); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if _, done, err := state.rolloutProgress(statefulSetKind); done != c.done || err != nil {
+			t.Errorf("%s: done = %v, %v, expected %v", c.name, done, err, c.done)
+		}
+	}
+}
+
+func TestParseWorkloadRejectsUnsupportedKinds(t *testing.T) {
+	cwd, cfg := newTestProject(t)
+
+	deploymentYamlPath := filepath.Join(cwd, "services", "go", "image", "k8s", "deployment.dev.yaml")
+	writeTestFile(t, deploymentYamlPath, strings.Replace(testDeploymentYaml, "kind: Deployment", "kind: ReplicaSet", 1))
+
+	_, err := ParseWorkload(cfg, deploymentYamlPath, "image")
+
+	var manifestErr *ManifestError
+	if !errors.As(err, &manifestErr) || !strings.Contains(err.Error(), "ReplicaSet") {
+		t.Errorf("expected a *ManifestError naming the kind, got %v", err)
+	}
+
+	writeTestFile(t, deploymentYamlPath, strings.Replace(testDeploymentYaml, "  name: udecrypt-image-service-deployment\n", "", 1))
+
+	workload, err := ParseWorkload(cfg, deploymentYamlPath, "image")
+	if err != nil {
+		t.Fatalf("ParseWorkload returned an error: %v", err)
+	}
+
+	if workload.Kind != deploymentKind || workload.Name != testDeploymentName {
+		t.Errorf("expected an unnamed Deployment to get the default name, got %+v", workload)
+	}
+}
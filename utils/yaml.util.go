@@ -1,181 +1,355 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
-	"github.com/nowshad-hossain-rahat/k8s-deployer/constants"
 	"github.com/nowshad-hossain-rahat/k8s-deployer/types"
 	"gopkg.in/yaml.v3"
 )
 
+// Kubernetes manifest directory for service types missing from KubernetesConfig.Directory
+const defaultKubernetesDirectory = "k8s"
+
 func GetDeploymentAndServiceYamlPaths(
 	cfg *types.K8sDeployerConfig,
+	env *types.EnvironmentConfig,
 	serviceDirectoryRoot string,
-	mode, serviceType, serviceName string,
+	serviceType, serviceName string,
 ) (string, string) {
-	var deploymentYamlPath, serviceYamlPath string
-
-	if mode == constants.Dev {
-		if serviceType == constants.Go {
-			deploymentYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Go,
-				cfg.KubernetesConfig.Files.Dev.Deployment,
-			)
-
-			serviceYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Go,
-				cfg.KubernetesConfig.Files.Dev.Service,
-			)
-		} else {
-			deploymentYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Dotnet,
-				cfg.KubernetesConfig.Files.Dev.Deployment,
-			)
-
-			serviceYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Dotnet,
-				cfg.KubernetesConfig.Files.Dev.Service,
-			)
+	kubernetesDirectory, found := lookupByType(cfg.KubernetesConfig.Directory, serviceType)
+
+	if !found {
+		kubernetesDirectory = defaultKubernetesDirectory
+	}
+
+	deploymentYamlPath := path.Join(serviceDirectoryRoot, kubernetesDirectory, env.Files.Deployment)
+	serviceYamlPath := path.Join(serviceDirectoryRoot, kubernetesDirectory, env.Files.Service)
+
+	return deploymentYamlPath, serviceYamlPath
+}
+
+// decodeYamlDocuments decodes every document of a YAML stream separated by `---`.
+func decodeYamlDocuments(data []byte) ([]*yaml.Node, error) {
+	var documents []*yaml.Node
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var document yaml.Node
+
+		err := decoder.Decode(&document)
+
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		} else if err != nil {
+			return nil, err
 		}
-	} else {
-		if serviceType == constants.Go {
-			deploymentYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Go,
-				cfg.KubernetesConfig.Files.Prod.Deployment,
-			)
-
-			serviceYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Go,
-				cfg.KubernetesConfig.Files.Prod.Service,
-			)
-		} else {
-			deploymentYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Dotnet,
-				cfg.KubernetesConfig.Files.Prod.Deployment,
-			)
-
-			serviceYamlPath = path.Join(
-				serviceDirectoryRoot,
-				cfg.KubernetesConfig.Directory.Dotnet,
-				cfg.KubernetesConfig.Files.Prod.Service,
-			)
+
+		documents = append(documents, &document)
+	}
+}
+
+// workloadDocument returns the first document with pod template containers, or the first
+// document when there is none, so the caller can report what is missing.
+func workloadDocument(documents []*yaml.Node) *yaml.Node {
+	for _, document := range documents {
+		if imageKey, _ := findContainerImageNode(document); imageKey != nil {
+			return document
 		}
 	}
 
-	return deploymentYamlPath, serviceYamlPath
+	if len(documents) > 0 {
+		return documents[0]
+	}
+
+	return nil
 }
 
-func ParseYaml(yamlPath string) (*types.Deployment, error) {
-	data, err := os.ReadFile(yamlPath)
+// UpdateYaml replaces the image of the first container in the deployment YAML file.
+// The file is decoded into a yaml.Node tree only to locate the image scalar, and that
+// scalar is then rewritten in the original bytes, so every other field, comment, the key
+// order and the indentation are written back exactly as they were.
+func UpdateYaml(yamlPath, dockerImage string) error {
+	_, modifiedData, err := renderUpdatedYaml(yamlPath, dockerImage)
 	if err != nil {
-		return nil, fmt.Errorf("error reading YAML file: %v", err)
+		return err
 	}
 
-	var deployment types.Deployment
-	err = yaml.Unmarshal(data, &deployment)
+	info, err := os.Stat(yamlPath)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing YAML file: %v", err)
+		return err
 	}
 
-	return &deployment, nil
+	// Write back to the file
+	if err := os.WriteFile(yamlPath, modifiedData, info.Mode().Perm()); err != nil {
+		return &ManifestError{Path: yamlPath, Reason: fmt.Sprintf("error writing YAML file: %v", err)}
+	}
+
+	return nil
 }
 
-func UpdateYaml(yamlPath string, deployment *types.Deployment) error {
-	// Marshal modified struct back to YAML
-	modifiedData, err := yaml.Marshal(deployment)
+// PreviewYamlUpdate returns the diff UpdateYaml would make to the deployment YAML, without
+// writing it.
+func PreviewYamlUpdate(yamlPath, dockerImage string) (string, error) {
+	data, modifiedData, err := renderUpdatedYaml(yamlPath, dockerImage)
+	if err != nil {
+		return "", err
+	}
 
+	return UnifiedDiff(yamlPath, yamlPath, string(data), string(modifiedData)), nil
+}
+
+// renderUpdatedYaml returns the manifest as it is on disk and with its container image
+// replaced.
+func renderUpdatedYaml(yamlPath, dockerImage string) ([]byte, []byte, error) {
+	data, err := readManifest(yamlPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// Write back to the file
-	err = os.WriteFile(yamlPath, modifiedData, 0644)
+	documents, err := decodeYamlDocuments(data)
 	if err != nil {
-		return err
+		return nil, nil, &ManifestError{Path: yamlPath, Reason: fmt.Sprintf("error parsing YAML file: %v", err)}
+	}
+
+	// Node positions count from the start of the stream, so the scalar is replaced in place
+	// whichever document it is in
+	var imageKey, imageValue *yaml.Node
+
+	if document := workloadDocument(documents); document != nil {
+		imageKey, imageValue = findContainerImageNode(document)
+	}
+
+	if imageKey == nil {
+		return nil, nil, &ManifestError{Path: yamlPath, Reason: "Failed to find container image in deployment YAML file"}
+	}
+
+	modifiedData, err := replaceScalarNode(data, imageKey, imageValue, dockerImage)
+	if err != nil {
+		return nil, nil, &ManifestError{Path: yamlPath, Reason: strings.TrimPrefix(err.Error(), "[!] ")}
+	}
+
+	return data, modifiedData, nil
+}
+
+// readManifest reads a Kubernetes manifest, telling a missing file apart from other errors.
+func readManifest(yamlPath string) ([]byte, error) {
+	data, err := os.ReadFile(yamlPath)
+
+	if os.IsNotExist(err) {
+		return nil, &ManifestMissingError{Path: yamlPath}
+	} else if err != nil {
+		return nil, &ManifestError{Path: yamlPath, Reason: fmt.Sprintf("error reading YAML file: %v", err)}
+	}
+
+	return data, nil
+}
+
+// checkManifestsExist returns a *ManifestMissingError for the first path that is not a file.
+func checkManifestsExist(paths ...string) error {
+	for _, manifestPath := range paths {
+		if info, err := os.Stat(manifestPath); err != nil || info.IsDir() {
+			return &ManifestMissingError{Path: manifestPath}
+		}
 	}
 
 	return nil
 }
 
-// ParseVersion takes a Docker image string and returns the current version and the next version to be used.
-// If the image does not contain a version, it uses the default version 1.0.0.
-// It increments the patch version by 1 and ensures that the version is valid.
-// If the patch version is over 99, it resets the patch version to 0 and increments the minor version by 1.
-// If the minor version is over 99, it resets the minor version to 0 and increments the major version by 1.
-func ParseVersion(dockerImage string) (string, string) {
-	versionStr := dockerImage[strings.LastIndex(dockerImage, ":")+1:]
+// findContainerImageNode returns the key and value nodes of the image of the first
+// container of the pod template in a decoded workload document, e.g.
+// spec.template.spec.containers[0].image for a Deployment.
+func findContainerImageNode(document *yaml.Node) (*yaml.Node, *yaml.Node) {
+	node := documentRoot(document)
 
-	if versionStr == "" {
-		versionStr = "1.0.0"
+	_, kind := mappingEntry(node, "kind")
 
-		fmt.Println("[+] Image: ", dockerImage)
-		fmt.Println("[!] No version was set in YAML for the Docker image. Using default version: 1.0.0")
-	} else {
-		fmt.Println("[+] Image: ", dockerImage)
-		fmt.Println("[+] Current Version: ", versionStr)
+	for _, key := range append(podTemplateSpecPath(scalarValue(kind)), "containers") {
+		_, node = mappingEntry(node, key)
+
+		if node == nil {
+			return nil, nil
+		}
+	}
+
+	if node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		return nil, nil
+	}
+
+	return mappingEntry(node.Content[0], "image")
+}
+
+// documentRoot returns the top-level node of a decoded document.
+func documentRoot(document *yaml.Node) *yaml.Node {
+	if document.Kind == yaml.DocumentNode {
+		if len(document.Content) == 0 {
+			return nil
+		}
+
+		return document.Content[0]
 	}
 
-	majorStr := versionStr[:1]
-	minorStr := versionStr[2:3]
-	patchStr := versionStr[4:]
+	return document
+}
 
-	if majorStr == "" || minorStr == "" || patchStr == "" {
-		majorStr = "1"
-		minorStr = "0"
-		patchStr = "0"
+// scalarValue returns the value of a scalar node, or an empty string when there is none.
+func scalarValue(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
 	}
 
-	major, _ := strconv.Atoi(majorStr)
-	minor, _ := strconv.Atoi(minorStr)
-	patch, _ := strconv.Atoi(patchStr)
+	return node.Value
+}
 
-	if major < 0 {
-		major = 0
+// mappingEntry looks up a key in a mapping node and returns its key and value nodes.
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
 	}
 
-	if minor < 0 {
-		minor = 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
 	}
 
-	if patch < 0 {
-		patch = 0
+	return nil, nil
+}
+
+// replaceScalarNode swaps the source text of a single-line scalar value for newValue,
+// keeping the quoting style it was written with. Empty values (e.g. `image:`) are
+// filled in right after the colon of their key.
+func replaceScalarNode(data []byte, keyNode, valueNode *yaml.Node, newValue string) ([]byte, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+
+	if valueNode.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("[!] Container image in YAML file is not a scalar value")
 	}
 
-	patch = patch + 1
+	if valueNode.Value == "" && valueNode.Style == 0 {
+		start, err := lineOffset(lines, keyNode.Line, keyNode.Column)
+		if err != nil {
+			return nil, err
+		}
 
-	if patch > 99 {
-		patch = 0
-		minor = minor + 1
+		line := lines[keyNode.Line-1]
+		colon := strings.Index(line[start:], ":")
 
-		if minor > 99 {
-			minor = 0
-			major = major + 1
+		if colon < 0 {
+			return nil, fmt.Errorf("[!] Failed to locate the image key on line %d", keyNode.Line)
 		}
+
+		start += colon + 1
+		end := start + plainScalarLength(line[start:])
+		lines[keyNode.Line-1] = line[:start] + " " + newValue + line[end:]
+
+		return []byte(strings.Join(lines, "")), nil
 	}
 
-	newVersion := fmt.Sprintf("%d.%d.%d", major, minor, patch)
+	start, err := lineOffset(lines, valueNode.Line, valueNode.Column)
+	if err != nil {
+		return nil, err
+	}
 
-	return versionStr, newVersion
+	line := lines[valueNode.Line-1]
+	rest := line[start:]
+	var length int
+	var replacement string
+
+	switch valueNode.Style {
+	case yaml.DoubleQuotedStyle:
+		length = quotedScalarLength(rest, '"')
+		replacement = strconv.Quote(newValue)
+	case yaml.SingleQuotedStyle:
+		length = quotedScalarLength(rest, '\'')
+		replacement = "'" + strings.ReplaceAll(newValue, "'", "''") + "'"
+	case 0:
+		// A single-line plain scalar is written exactly as its value, which also ends it
+		// before the `,`, `}` or `]` of a flow collection
+		if !strings.HasPrefix(rest, valueNode.Value) {
+			return nil, fmt.Errorf("[!] Multi-line image value on line %d is not supported", valueNode.Line)
+		}
+
+		length = len(valueNode.Value)
+		replacement = newValue
+	default:
+		return nil, fmt.Errorf("[!] Unsupported YAML style for the image value on line %d", valueNode.Line)
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("[!] Unterminated image value on line %d", valueNode.Line)
+	}
+
+	lines[valueNode.Line-1] = line[:start] + replacement + rest[length:]
+
+	return []byte(strings.Join(lines, "")), nil
 }
 
-func ParseDockerImagePath(cfg *types.K8sDeployerConfig, mode, serviceName, version string) string {
-	containerRegistry := cfg.DockerContainerRegistry.Dev
+// lineOffset converts a 1-based line and character column reported by yaml.v3 into a
+// byte offset within that line.
+func lineOffset(lines []string, line, column int) (int, error) {
+	if line < 1 || line > len(lines) {
+		return 0, fmt.Errorf("[!] YAML position %d:%d is out of range", line, column)
+	}
 
-	if mode == constants.Prod {
-		containerRegistry = cfg.DockerContainerRegistry.Prod
+	offset := 0
+
+	for i := 1; i < column; i++ {
+		if offset >= len(lines[line-1]) {
+			return 0, fmt.Errorf("[!] YAML position %d:%d is out of range", line, column)
+		}
+
+		_, size := utf8.DecodeRuneInString(lines[line-1][offset:])
+		offset += size
 	}
 
+	return offset, nil
+}
+
+// plainScalarLength returns the length of a plain scalar up to a trailing comment or
+// the end of the line.
+func plainScalarLength(rest string) int {
+	end := len(strings.TrimRight(rest, "\r\n"))
+
+	if comment := strings.Index(rest[:end], " #"); comment >= 0 {
+		end = comment
+	}
+
+	if comment := strings.Index(rest[:end], "\t#"); comment >= 0 {
+		end = comment
+	}
+
+	return end
+}
+
+// quotedScalarLength returns the length of a quoted scalar including both quotes, or -1
+// when the closing quote is not on the same line.
+func quotedScalarLength(rest string, quote byte) int {
+	for i := 1; i < len(rest); i++ {
+		switch {
+		case quote == '"' && rest[i] == '\\':
+			i++
+		case rest[i] == quote && quote == '\'' && i+1 < len(rest) && rest[i+1] == '\'':
+			i++
+		case rest[i] == quote:
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+func ParseDockerImagePath(cfg *types.K8sDeployerConfig, env *types.EnvironmentConfig, serviceName, version string) string {
+	containerRegistry := env.Registry
+
 	dockerTag := fmt.Sprintf("%s_%s", cfg.DockerImagePrefix, serviceName)
 
 	if cfg.DockerImagePrefix == "" {
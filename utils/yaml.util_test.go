@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateYamlOnlyRewritesTheImageScalar(t *testing.T) {
+	// {{IMAGE}} marks the scalar that is rewritten, every other byte has to stay as it is
+	cases := map[string]string{
+		"plain with comment and repeated image": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: image # the same image as below
+  annotations:
+    previous-image: udecrypt_image:1.0.1
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: udecrypt_image:1.0.1
+      containers:
+        - name:   image
+          image:    {{IMAGE}}   # bumped by k8s-deployer
+          args: ["--image", "udecrypt_image:1.0.1"]
+        - name: sidecar
+          image: udecrypt_image:1.0.1
+`,
+		"double quoted": `kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - image: "{{IMAGE}}" # pinned
+          name: image
+`,
+		"single quoted": `kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: image
+          image: '{{IMAGE}}'
+`,
+		"flow style containers": `kind: Deployment
+spec:
+  template:
+    spec:
+      containers: [{name: image, image: {{IMAGE}}}, {name: sidecar, image: udecrypt_image:1.0.1}]
+`,
+		"flow style quoted": `kind: Deployment
+spec: {template: {spec: {containers: [{image: "{{IMAGE}}", name: image}]}}}
+`,
+		"windows line endings": "kind: Deployment\r\nspec:\r\n  template:\r\n    spec:\r\n      containers:\r\n        - image: {{IMAGE}}\r\n          name: image\r\n",
+		"second document": `# Configuration first
+apiVersion: v1
+kind: ConfigMap
+data:
+  image: udecrypt_image:1.0.1
+---
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - image: {{IMAGE}}
+`,
+	}
+
+	for name, template := range cases {
+		yamlPath := filepath.Join(t.TempDir(), "deployment.yaml")
+		writeTestFile(t, yamlPath, strings.Replace(template, "{{IMAGE}}", "udecrypt_image:1.0.1", 1))
+
+		if err := UpdateYaml(yamlPath, "registry.example.com:5000/udecrypt_image:1.0.2"); err != nil {
+			t.Errorf("%s: UpdateYaml returned an error: %v", name, err)
+			continue
+		}
+
+		expected := strings.Replace(template, "{{IMAGE}}", "registry.example.com:5000/udecrypt_image:1.0.2", 1)
+
+		if yaml := readTestFile(t, yamlPath); yaml != expected {
+			t.Errorf("%s: unexpected YAML:\n%s\nexpected:\n%s", name, yaml, expected)
+		}
+	}
+}
+
+func TestUpdateYamlFillsAnEmptyImage(t *testing.T) {
+	yamlPath := filepath.Join(t.TempDir(), "deployment.yaml")
+	writeTestFile(t, yamlPath, "kind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - image: # set on deploy\n")
+
+	if err := UpdateYaml(yamlPath, "udecrypt_image:1.0.2"); err != nil {
+		t.Fatalf("UpdateYaml returned an error: %v", err)
+	}
+
+	expected := "kind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - image: udecrypt_image:1.0.2 # set on deploy\n"
+
+	if yaml := readTestFile(t, yamlPath); yaml != expected {
+		t.Errorf("unexpected YAML:\n%s", yaml)
+	}
+}
+
+func TestUpdateYamlRefusesMultiLineImages(t *testing.T) {
+	yamlPath := filepath.Join(t.TempDir(), "deployment.yaml")
+	original := "kind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - image: udecrypt_image\n            :1.0.1\n"
+	writeTestFile(t, yamlPath, original)
+
+	if err := UpdateYaml(yamlPath, "udecrypt_image:1.0.2"); err == nil {
+		t.Errorf("expected an error for a multi-line image")
+	}
+
+	if yaml := readTestFile(t, yamlPath); yaml != original {
+		t.Errorf("the YAML changed although the update failed:\n%s", yaml)
+	}
+}